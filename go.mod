@@ -4,6 +4,9 @@ go 1.25.1
 
 require (
 	github.com/go-chi/chi v1.5.5
+	github.com/jackc/pgx/v5 v5.6.0
+	github.com/lestrrat-go/jwx v1.1.0
+	github.com/rivo/uniseg v0.4.7
 	gorm.io/driver/sqlite v1.6.0
 )
 
@@ -12,12 +15,10 @@ require (
 	github.com/goccy/go-json v0.3.5 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
-	github.com/jackc/pgx/v5 v5.6.0 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	github.com/lestrrat-go/backoff/v2 v2.0.7 // indirect
 	github.com/lestrrat-go/httpcc v1.0.0 // indirect
 	github.com/lestrrat-go/iter v1.0.0 // indirect
-	github.com/lestrrat-go/jwx v1.1.0 // indirect
 	github.com/lestrrat-go/option v1.0.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/sync v0.19.0 // indirect
@@ -29,7 +30,7 @@ require (
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	golang.org/x/crypto v0.46.0
-	golang.org/x/text v0.32.0 // indirect
+	golang.org/x/text v0.32.0
 	gorm.io/driver/postgres v1.6.0
 	gorm.io/gorm v1.30.0
 )
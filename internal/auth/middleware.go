@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"net/http"
 
 	"github.com/go-chi/jwtauth"
@@ -28,4 +29,39 @@ func Authenticator(next http.Handler) http.Handler {
 		// Token is authenticated, pass user ID through
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
-}
\ No newline at end of file
+}
+
+// OptionalAuthenticator passes the user ID through when the request carries a
+// valid token and lets anonymous requests continue without one.
+func OptionalAuthenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, claims, err := jwtauth.FromContext(r.Context())
+		if err != nil || token == nil || jwt.Validate(token) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userId, ok := claims["user_id"].(float64)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), int64(userId))))
+	})
+}
+
+// AdminToken lets requests through that carry token in the X-Admin-Token
+// header. Without a token configured nobody gets through.
+func AdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get("X-Admin-Token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
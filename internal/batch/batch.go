@@ -0,0 +1,322 @@
+// Package batch groups items queued one at a time into batches that are
+// flushed together, once enough items are queued or the oldest one has
+// waited long enough. Every item gets its own result back.
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrFull = errors.New("batch queue is full")
+	ErrClosed = errors.New("batcher is closed")
+)
+
+// FlushFunc stores a batch. It may update the items in place and returns
+// an error per item, or nil when all of them went through.
+type FlushFunc[T any] func(ctx context.Context, items []T) []error
+
+type Result[T any] struct {
+	Item T
+	Err error
+}
+
+type Reason string
+
+const (
+	ReasonFull Reason = "full"
+	ReasonTimer Reason = "timer"
+	ReasonDrain Reason = "drain"
+)
+
+type FlushInfo struct {
+	Size int
+	Failed int
+	Reason Reason
+	Latency time.Duration
+}
+
+// Metrics is told about what a Batcher does, e.g. to export it.
+type Metrics interface {
+	Flushed(info FlushInfo)
+	Rejected(err error)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Flushed(FlushInfo) {}
+func (noMetrics) Rejected(error) {}
+
+type Option[T any] func(*Batcher[T])
+
+func WithClock[T any](clock Clock) Option[T] {
+	return func(b *Batcher[T]) {
+		b.clock = clock
+	}
+}
+
+func WithMetrics[T any](metrics Metrics) Option[T] {
+	return func(b *Batcher[T]) {
+		b.metrics = metrics
+	}
+}
+
+// WithShard picks the worker of an item by key, items with the same key
+// are flushed in the order they were added.
+func WithShard[T any](key func(T) uint64) Option[T] {
+	return func(b *Batcher[T]) {
+		b.shard = key
+	}
+}
+
+type entry[T any] struct {
+	item T
+	done chan Result[T]
+}
+
+// workerStats is what a worker shares about its flushes, for Backlog.
+type workerStats struct {
+	// latency is the moving average of how long flushes take
+	latency atomic.Int64
+	// flushStart is when the running flush started in unix nanoseconds, 0
+	// between flushes
+	flushStart atomic.Int64
+}
+
+type Batcher[T any] struct {
+	cfg Config
+	flush FlushFunc[T]
+	clock Clock
+	metrics Metrics
+	shard func(T) uint64
+	queues []chan entry[T]
+	stats []workerStats
+
+	// closed is set under mu once Close is called, after that nothing new
+	// is queued and the workers drain what is left. done is set once ctx
+	// is done and the workers have stopped.
+	mu sync.RWMutex
+	closed bool
+	done bool
+	stop chan struct{}
+	drained chan int
+	// workers is done once every worker has returned
+	workers sync.WaitGroup
+}
+
+// New starts the workers of a Batcher, they flush until ctx is done or
+// Close is called. Once ctx is done items still queued get ErrClosed.
+func New[T any](ctx context.Context, cfg Config, flush FlushFunc[T], opts ...Option[T]) *Batcher[T] {
+	b := &Batcher[T]{
+		cfg: cfg,
+		flush: flush,
+		clock: realClock{},
+		metrics: noMetrics{},
+		shard: func(T) uint64 { return 0 },
+		stop: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	workers := max(cfg.Workers, 1)
+	b.drained = make(chan int, workers)
+	b.queues = make([]chan entry[T], workers)
+	b.stats = make([]workerStats, workers)
+	for i := range b.queues {
+		b.queues[i] = make(chan entry[T], cfg.QueueSize)
+		b.workers.Add(1)
+		go func() {
+			defer b.workers.Done()
+			b.worker(ctx, b.queues[i], &b.stats[i])
+		}()
+	}
+	return b
+}
+
+func (b *Batcher[T]) queueOf(item T) int {
+	return int(b.shard(item) % uint64(len(b.queues)))
+}
+
+// Add queues item and returns the channel its result is delivered on.
+func (b *Batcher[T]) Add(item T) (<-chan Result[T], error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed || b.done {
+		b.metrics.Rejected(ErrClosed)
+		return nil, ErrClosed
+	}
+
+	e := entry[T]{item: item, done: make(chan Result[T], 1)}
+	select {
+	case b.queues[b.queueOf(item)] <- e:
+		return e.done, nil
+	default:
+		b.metrics.Rejected(ErrFull)
+		return nil, ErrFull
+	}
+}
+
+// Backlog estimates how long the worker of item takes to flush what is
+// queued for it, going by how long its recent flushes took. A flush that is
+// taking longer than those, such as one stuck on an unreachable database,
+// counts for every batch still to come.
+func (b *Batcher[T]) Backlog(item T) time.Duration {
+	i := b.queueOf(item)
+	stats := &b.stats[i]
+
+	latency := time.Duration(stats.latency.Load())
+	running := time.Duration(0)
+	if start := stats.flushStart.Load(); start != 0 {
+		running = b.clock.Now().Sub(time.Unix(0, start))
+	}
+	latency = max(latency, running)
+	if latency == 0 {
+		latency = b.cfg.MaxWait
+	}
+
+	batches := (len(b.queues[i]) + b.cfg.MaxBatchSize - 1) / b.cfg.MaxBatchSize
+	return time.Duration(batches+1) * latency - running
+}
+
+// Close stops accepting items and waits until the workers have flushed
+// everything still queued. It returns how many items were drained.
+func (b *Batcher[T]) Close(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	total := 0
+	for range b.queues {
+		select {
+		case n := <-b.drained:
+			total += n
+		case <-ctx.Done():
+			return total, ctx.Err()
+		}
+	}
+	return total, nil
+}
+
+// Wait blocks until the workers have returned, after Close has drained the
+// queues or once ctx is done. A Close that gave up on its deadline leaves
+// them flushing, cancel ctx and Wait before closing what flush uses.
+func (b *Batcher[T]) Wait() {
+	b.workers.Wait()
+}
+
+// reject answers everything in queue with ErrClosed once its worker has
+// stopped. It holds mu so nothing is added while it empties the queue.
+func (b *Batcher[T]) reject(queue chan entry[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	for {
+		select {
+		case e := <-queue:
+			b.metrics.Rejected(ErrClosed)
+			e.done <- Result[T]{Item: e.item, Err: ErrClosed}
+		default:
+			return
+		}
+	}
+}
+
+func (b *Batcher[T]) worker(ctx context.Context, queue chan entry[T], stats *workerStats) {
+	tuner := newTuner(b.cfg)
+
+	ticker := b.clock.NewTicker(tuner.wait)
+	defer ticker.Stop()
+
+	batch := make([]entry[T], 0, b.cfg.MaxBatchSize)
+
+	flush := func(reason Reason) {
+		if len(batch) == 0 {
+			return
+		}
+
+		items := make([]T, len(batch))
+		for i, e := range batch {
+			items[i] = e.item
+		}
+
+		start := b.clock.Now()
+		stats.flushStart.Store(start.UnixNano())
+		errs := b.flush(ctx, items)
+		latency := b.clock.Now().Sub(start)
+		stats.flushStart.Store(0)
+
+		failed := 0
+		for i, e := range batch {
+			var err error
+			if i < len(errs) {
+				err = errs[i]
+			}
+			if err != nil {
+				failed++
+			}
+			e.done <- Result[T]{Item: items[i], Err: err}
+		}
+
+		b.metrics.Flushed(FlushInfo{Size: len(batch), Failed: failed, Reason: reason, Latency: latency})
+		if tuner.observe(len(batch), reason == ReasonFull, latency) {
+			ticker.Reset(tuner.wait)
+		}
+		stats.latency.Store(int64(tuner.latency))
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the batch in hand is flushed, what is still queued is turned
+			// away so every item gets a result
+			drained := len(batch)
+			flush(ReasonDrain)
+			b.reject(queue)
+			b.drained <- drained
+			return
+		case <-b.stop:
+			// nothing is queued after Close, so an empty queue means done
+			drained := len(batch)
+		drain:
+			for {
+				select {
+				case e := <-queue:
+					batch = append(batch, e)
+					drained++
+					if len(batch) >= b.cfg.MaxBatchSize {
+						flush(ReasonDrain)
+					}
+				default:
+					break drain
+				}
+			}
+			flush(ReasonDrain)
+			b.drained <- drained
+			return
+		case e := <-queue:
+			batch = append(batch, e)
+			if len(batch) >= tuner.size {
+				flush(ReasonFull)
+			}
+		case <-ticker.C():
+			if len(batch) == 0 {
+				if tuner.idle() {
+					ticker.Reset(tuner.wait)
+				}
+				continue
+			}
+			flush(ReasonTimer)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	now time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward and fires the tickers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || c.now.Before(t.next) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		t.next = c.now.Add(t.period)
+	}
+}
+
+type fakeTicker struct {
+	clock *fakeClock
+	c chan time.Time
+	period time.Duration
+	next time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period = d
+	t.next = t.clock.now.Add(d)
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
+
+type recordingMetrics struct {
+	mu sync.Mutex
+	flushes []FlushInfo
+	rejected []error
+}
+
+func (m *recordingMetrics) Flushed(info FlushInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushes = append(m.flushes, info)
+}
+
+func (m *recordingMetrics) Rejected(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected = append(m.rejected, err)
+}
+
+func waitResult[T any](t *testing.T, done <-chan Result[T]) Result[T] {
+	t.Helper()
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a result")
+		return Result[T]{}
+	}
+}
+
+// advanceUntil moves clock forward in steps of d until done delivers. The
+// worker may see a tick before it has taken every queued item, so a
+// single step is not always enough.
+func advanceUntil[T any](t *testing.T, clock *fakeClock, d time.Duration, done <-chan Result[T]) Result[T] {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		clock.Advance(d)
+		select {
+		case res := <-done:
+			return res
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	t.Fatal("no result after advancing the clock")
+	return Result[T]{}
+}
+
+func TestFlushOnSize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 3
+	cfg.QueueSize = 3
+
+	var mu sync.Mutex
+	var batches [][]int
+	b := New(context.Background(), cfg, func(ctx context.Context, items []int) []error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, append([]int{}, items...))
+		return nil
+	}, WithClock[int](newFakeClock()))
+
+	var results []<-chan Result[int]
+	for i := 1; i <= 3; i++ {
+		done, err := b.Add(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, done)
+	}
+
+	// the clock never moves, only the size can trigger this flush
+	for i, done := range results {
+		if res := waitResult(t, done); res.Err != nil || res.Item != i+1 {
+			t.Errorf("unexpected result %+v", res)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Errorf("expected one batch of 3, got %v", batches)
+	}
+}
+
+func TestFlushOnTimer(t *testing.T) {
+	cfg := DefaultConfig()
+	clock := newFakeClock()
+	metrics := &recordingMetrics{}
+
+	b := New(context.Background(), cfg, func(ctx context.Context, items []int) []error {
+		return nil
+	}, WithClock[int](clock), WithMetrics[int](metrics))
+
+	done, err := b.Add(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("expected nothing to be flushed before the wait is up")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	advanceUntil(t, clock, cfg.MaxWait, done)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.flushes) != 1 || metrics.flushes[0].Reason != ReasonTimer || metrics.flushes[0].Size != 1 {
+		t.Errorf("expected one timer flush of 1 item, got %+v", metrics.flushes)
+	}
+}
+
+func TestPerItemResults(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 4
+	metrics := &recordingMetrics{}
+
+	errOdd := errors.New("odd")
+	b := New(context.Background(), cfg, func(ctx context.Context, items []int) []error {
+		errs := make([]error, len(items))
+		for i := range items {
+			if items[i]%2 == 1 {
+				errs[i] = errOdd
+			}
+			items[i] *= 10
+		}
+		return errs
+	}, WithClock[int](newFakeClock()), WithMetrics[int](metrics))
+
+	var results []<-chan Result[int]
+	for i := 1; i <= 4; i++ {
+		done, _ := b.Add(i)
+		results = append(results, done)
+	}
+
+	for i, done := range results {
+		res := waitResult(t, done)
+		if res.Item != (i+1)*10 {
+			t.Errorf("expected the item updated by the flush, got %d", res.Item)
+		}
+		if odd := (i+1)%2 == 1; odd != errors.Is(res.Err, errOdd) {
+			t.Errorf("wrong error for item %d: %v", i+1, res.Err)
+		}
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.flushes) != 1 || metrics.flushes[0].Failed != 2 {
+		t.Errorf("expected one flush with 2 failed items, got %+v", metrics.flushes)
+	}
+}
+
+func TestFullQueueAndClose(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 1
+	cfg.QueueSize = 1
+	metrics := &recordingMetrics{}
+
+	block := make(chan struct{})
+	var flushed []int
+	b := New(context.Background(), cfg, func(ctx context.Context, items []int) []error {
+		<-block
+		flushed = append(flushed, items...)
+		return nil
+	}, WithClock[int](newFakeClock()), WithMetrics[int](metrics))
+
+	// the worker is stuck on the first item, the next one fills the queue
+	var results []<-chan Result[int]
+	for i := 0; ; i++ {
+		done, err := b.Add(i)
+		if errors.Is(err, ErrFull) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, done)
+	}
+
+	close(block)
+	drained, err := b.Close(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, done := range results {
+		waitResult(t, done)
+	}
+	if len(flushed) != len(results) || drained > len(results) {
+		t.Errorf("expected all %d queued items to be flushed, flushed %d and drained %d", len(results), len(flushed), drained)
+	}
+
+	if _, err := b.Add(99); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.rejected) != 2 || !errors.Is(metrics.rejected[0], ErrFull) || !errors.Is(metrics.rejected[1], ErrClosed) {
+		t.Errorf("expected a full and a closed rejection, got %v", metrics.rejected)
+	}
+}
+
+func TestCancelRejectsQueued(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 1
+	cfg.QueueSize = 4
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 1)
+	b := New(ctx, cfg, func(ctx context.Context, items []int) []error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return []error{ctx.Err()}
+	}, WithClock[int](newFakeClock()))
+
+	first, _ := b.Add(0)
+	<-started
+	var queued []<-chan Result[int]
+	for i := 1; i <= 3; i++ {
+		done, err := b.Add(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, done)
+	}
+
+	cancel()
+	if res := waitResult(t, first); !errors.Is(res.Err, context.Canceled) {
+		t.Errorf("expected the running flush to fail with its context, got %v", res.Err)
+	}
+	// the worker may still pick up a queued item before it notices ctx,
+	// that flush fails with ctx the same way
+	for _, done := range queued {
+		if res := waitResult(t, done); !errors.Is(res.Err, ErrClosed) && !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("expected item %d to be turned away, got %v", res.Item, res.Err)
+		}
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Second)
+	defer closeCancel()
+	if _, err := b.Close(closeCtx); err != nil {
+		t.Errorf("expected Close to return once the worker stopped, got %v", err)
+	}
+	if _, err := b.Add(99); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after the context is done, got %v", err)
+	}
+}
+
+func TestWaitAfterCloseTimesOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var stopped bool
+	b := New(ctx, cfg, func(ctx context.Context, items []int) []error {
+		close(started)
+		<-ctx.Done()
+		stopped = true
+		return nil
+	}, WithClock[int](newFakeClock()))
+
+	b.Add(1)
+	<-started
+
+	// Close gives up while the flush is stuck, only cancelling ctx stops it
+	closeCtx, closeCancel := context.WithCancel(context.Background())
+	closeCancel()
+	if _, err := b.Close(closeCtx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Close to give up, got %v", err)
+	}
+	cancel()
+
+	b.Wait()
+	if !stopped {
+		t.Error("expected Wait to return only after the flush stopped")
+	}
+}
+
+func TestShardKeepsOrder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 3
+	cfg.Workers = 4
+
+	type item struct {
+		key uint64
+		n int
+	}
+
+	var mu sync.Mutex
+	seen := map[uint64][]int{}
+	b := New(context.Background(), cfg, func(ctx context.Context, items []item) []error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, it := range items {
+			seen[it.key] = append(seen[it.key], it.n)
+		}
+		return nil
+	}, WithShard(func(it item) uint64 { return it.key }))
+
+	for n := 0; n < 60; n++ {
+		if _, err := b.Add(item{key: uint64(n % 5), n: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, ns := range seen {
+		for i := 1; i < len(ns); i++ {
+			if ns[i] < ns[i-1] {
+				t.Errorf("items of key %d flushed out of order: %v", key, ns)
+				break
+			}
+		}
+	}
+}
+
+func TestTunerAdaptive(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Adaptive = true
+	tuner := newTuner(cfg)
+
+	if tuner.size != cfg.MinBatchSize {
+		t.Fatalf("expected to start at the min batch size, got %d", tuner.size)
+	}
+
+	// sustained load grows batches up to the max
+	for i := 0; i < 20; i++ {
+		tuner.observe(tuner.size, true, 10*time.Millisecond)
+	}
+	if tuner.size != cfg.MaxBatchSize {
+		t.Errorf("expected batches to grow to %d under load, got %d", cfg.MaxBatchSize, tuner.size)
+	}
+
+	// light traffic flushes sooner, but not more often than a flush takes
+	for i := 0; i < 20; i++ {
+		tuner.observe(1, false, 8*time.Millisecond)
+	}
+	if tuner.wait >= cfg.MaxWait || tuner.wait < 8*time.Millisecond {
+		t.Errorf("expected the wait to shrink towards the flush latency, got %v", tuner.wait)
+	}
+	if tuner.size != cfg.MinBatchSize {
+		t.Errorf("expected batches to shrink back to %d, got %d", cfg.MinBatchSize, tuner.size)
+	}
+
+	// fast flushes let the wait go down to the min
+	for i := 0; i < 20; i++ {
+		tuner.observe(1, false, time.Millisecond)
+	}
+	if tuner.wait != cfg.MinWait {
+		t.Errorf("expected the wait to reach %v, got %v", cfg.MinWait, tuner.wait)
+	}
+
+	// and it recovers once batches fill up again
+	tuner.observe(tuner.size, true, time.Millisecond)
+	if tuner.wait != cfg.MaxWait {
+		t.Errorf("expected the wait to go back to %v under load, got %v", cfg.MaxWait, tuner.wait)
+	}
+}
+
+func TestTunerIdle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Adaptive = true
+	tuner := newTuner(cfg)
+
+	for i := 0; i < 20; i++ {
+		tuner.observe(1, false, time.Millisecond)
+	}
+	if tuner.wait != cfg.MinWait {
+		t.Fatalf("expected the wait to reach %v, got %v", cfg.MinWait, tuner.wait)
+	}
+
+	// empty ticks back the wait off instead of waking up at MinWait for good
+	for i := 0; i < 20; i++ {
+		tuner.idle()
+	}
+	if tuner.wait != cfg.MaxWait {
+		t.Errorf("expected an idle worker to back off to %v, got %v", cfg.MaxWait, tuner.wait)
+	}
+	if tuner.idle() {
+		t.Errorf("expected no change once at %v", cfg.MaxWait)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBatchSize = 2
+	cfg.QueueSize = 4
+	clock := newFakeClock()
+
+	started := make(chan struct{}, 1)
+	block := make(chan struct{})
+	defer close(block)
+	b := New(context.Background(), cfg, func(ctx context.Context, items []int) []error {
+		started <- struct{}{}
+		<-block
+		return nil
+	}, WithClock[int](clock))
+
+	if got := b.Backlog(0); got != cfg.MaxWait {
+		t.Errorf("expected MaxWait before anything was flushed, got %v", got)
+	}
+
+	b.Add(1)
+	b.Add(2)
+	<-started
+	for i := 3; i <= 6; i++ {
+		if _, err := b.Add(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the stuck flush has taken 2s so far, the 2 batches queued behind it
+	// are expected to take as long each
+	clock.Advance(2 * time.Second)
+	if got := b.Backlog(0); got != 4*time.Second {
+		t.Errorf("expected a backlog of 4s, got %v", got)
+	}
+}
+
+func TestTunerFixed(t *testing.T) {
+	tuner := newTuner(DefaultConfig())
+
+	tuner.observe(100, true, time.Second)
+	tuner.observe(1, false, time.Millisecond)
+
+	if tuner.size != 100 || tuner.wait != 50*time.Millisecond {
+		t.Errorf("expected batching to stay fixed, got size %d wait %v", tuner.size, tuner.wait)
+	}
+}
@@ -0,0 +1,124 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Config struct {
+	// MaxBatchSize is the most items flushed at once.
+	MaxBatchSize int
+	// MaxWait is the longest an item sits in the queue before its batch is
+	// flushed, however small the batch is.
+	MaxWait time.Duration
+	// QueueSize is how many items may wait for each worker before new ones
+	// are turned away, at least one full batch.
+	QueueSize int
+	// Workers is how many batches are flushed in parallel. Items with the
+	// same shard key always go to the same worker and stay in order.
+	Workers int
+
+	// Adaptive starts batches at MinBatchSize and grows them towards
+	// MaxBatchSize while batches keep filling up, and flushes sooner than
+	// MaxWait, down to MinWait, while traffic is light.
+	Adaptive bool
+	MinBatchSize int
+	MinWait time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxBatchSize: 100,
+		MaxWait: 50 * time.Millisecond,
+		QueueSize: 1000,
+		Workers: 1,
+		MinBatchSize: 10,
+		MinWait: 5 * time.Millisecond,
+	}
+}
+
+func (c Config) Validate() error {
+	switch {
+	case c.MaxBatchSize <= 0:
+		return errors.New("batch size must be positive")
+	case c.MaxWait <= 0:
+		return errors.New("batch wait must be positive")
+	case c.Workers <= 0:
+		return errors.New("workers must be positive")
+	case c.QueueSize < c.MaxBatchSize:
+		return fmt.Errorf("queue size %d cannot hold a batch of %d", c.QueueSize, c.MaxBatchSize)
+	case c.Adaptive && (c.MinBatchSize <= 0 || c.MinBatchSize > c.MaxBatchSize):
+		return fmt.Errorf("min batch size must be between 1 and %d", c.MaxBatchSize)
+	case c.Adaptive && (c.MinWait <= 0 || c.MinWait > c.MaxWait):
+		return fmt.Errorf("min batch wait must be between 0 and %v", c.MaxWait)
+	}
+	return nil
+}
+
+// tuner holds the batch size and wait a worker currently uses. With
+// adaptive batching it adjusts them after every flush, otherwise they stay
+// at their maximum.
+type tuner struct {
+	cfg Config
+	size int
+	wait time.Duration
+	// latency is a moving average of how long flushes take
+	latency time.Duration
+}
+
+func newTuner(cfg Config) *tuner {
+	t := &tuner{cfg: cfg, size: cfg.MaxBatchSize, wait: cfg.MaxWait}
+	if cfg.Adaptive {
+		t.size = cfg.MinBatchSize
+	}
+	return t
+}
+
+// observe records a flush of n items that took latency, full tells whether
+// the batch was flushed for reaching the batch size. It reports whether the
+// wait changed.
+func (t *tuner) observe(n int, full bool, latency time.Duration) bool {
+	if n == 0 {
+		return false
+	}
+
+	if t.latency == 0 {
+		t.latency = latency
+	} else {
+		t.latency = (3*t.latency + latency) / 4
+	}
+	if !t.cfg.Adaptive {
+		return false
+	}
+
+	wait := t.wait
+	switch {
+	case full:
+		// sustained load, bigger batches mean fewer round trips
+		t.size = min(max(t.size*3/2, t.size+1), t.cfg.MaxBatchSize)
+		wait = t.cfg.MaxWait
+	case n < t.size/4:
+		// light traffic, items mostly wait on the timer. Flush sooner, but
+		// not more often than a flush takes
+		t.size = max(t.size*3/4, t.cfg.MinBatchSize)
+		wait = min(max(t.wait/2, t.latency, t.cfg.MinWait), t.cfg.MaxWait)
+	default:
+		wait = min(t.wait*2, t.cfg.MaxWait)
+	}
+
+	changed := wait != t.wait
+	t.wait = wait
+	return changed
+}
+
+// idle records a tick that found nothing to flush. Nothing is coming in,
+// so the wait backs off towards MaxWait rather than keep the worker waking
+// up at MinWait. It reports whether the wait changed.
+func (t *tuner) idle() bool {
+	if !t.cfg.Adaptive || t.wait == t.cfg.MaxWait {
+		return false
+	}
+	t.wait = min(t.wait*2, t.cfg.MaxWait)
+	return true
+}
@@ -0,0 +1,130 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+)
+
+const (
+	maxKeyLength = 255
+	maxBodySize = 1 << 20
+)
+
+// recorder passes a response through while keeping a copy to store.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Middleware makes requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key is handled and its response stored
+// for the user, retries with the same body get that response back without
+// the handler running again. Server errors are not stored so a retry can
+// still succeed.
+//
+// Anonymous clients cannot be told apart, so their keys are scoped to the
+// request itself: only a retry with the same body gets the stored response,
+// and the same key sent by another client with another body is unrelated.
+func Middleware(svc IdempotencyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				http.Error(w, "could not read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := requestHash(r, body)
+			userId, ok := auth.UserIDFromContext(r.Context())
+			if !ok {
+				key = hash + "/" + key
+			}
+
+			rec, err := svc.Begin(r.Context(), userId, key, hash)
+			switch {
+			case errors.Is(err, ErrKeyReused):
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, ErrInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			case rec != nil:
+				if rec.ContentType != "" {
+					w.Header().Set("Content-Type", rec.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.Status)
+				w.Write(rec.Body)
+				return
+			}
+
+			rw := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			// the response is out, the request context may be gone by now
+			ctx := context.WithoutCancel(r.Context())
+			if rw.status == 0 || rw.status >= 500 {
+				svc.Release(ctx, userId, key)
+				return
+			}
+
+			err = svc.Complete(ctx, &Record{
+				UserID: userId,
+				Key: key,
+				Status: rw.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body: rw.body.Bytes(),
+			})
+			if err != nil {
+				log.Printf("could not store response for idempotency key: %v", err)
+			}
+		})
+	}
+}
+
+// requestHash identifies what a key was used for, the same key sent to
+// another endpoint or with another body does not match.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method + " " + r.URL.Path + "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
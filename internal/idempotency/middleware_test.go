@@ -0,0 +1,188 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"gorm.io/gorm"
+)
+
+type mockRepo struct {
+	mu sync.Mutex
+	records map[string]Record
+}
+
+func NewMockRepo() *mockRepo {
+	return &mockRepo{records: map[string]Record{}}
+}
+
+func recordKey(userId int64, key string) string {
+	return fmt.Sprintf("%d/%s", userId, key)
+}
+
+func (r *mockRepo) InsertRecord(ctx context.Context, rec *Record) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.records[recordKey(rec.UserID, rec.Key)]; ok {
+		return false, nil
+	}
+	r.records[recordKey(rec.UserID, rec.Key)] = *rec
+	return true, nil
+}
+
+func (r *mockRepo) GetRecord(ctx context.Context, userId int64, key string) (*Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.records[recordKey(userId, key)]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &rec, nil
+}
+
+func (r *mockRepo) CompleteRecord(ctx context.Context, rec *Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := r.records[recordKey(rec.UserID, rec.Key)]
+	stored.Completed = rec.Completed
+	stored.Status = rec.Status
+	stored.ContentType = rec.ContentType
+	stored.Body = rec.Body
+	r.records[recordKey(rec.UserID, rec.Key)] = stored
+	return nil
+}
+
+func (r *mockRepo) DeleteRecord(ctx context.Context, userId int64, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, recordKey(userId, key))
+	return nil
+}
+
+func (r *mockRepo) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewService(ctx, NewMockRepo(), DefaultTTL)
+
+	calls := 0
+	status := http.StatusCreated
+	handler := Middleware(svc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ID":1}`))
+	}))
+
+	do := func(userId int64, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tweet", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		req = req.WithContext(auth.WithUserID(req.Context(), userId))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct{
+		name string
+		userId int64
+		key string
+		body string
+		expectedStatus int
+		expectedCalls int
+	}{
+		{"FirstRequest", 7, "a", `{"text":"hi"}`, http.StatusCreated, 1},
+		{"Retry_Replayed", 7, "a", `{"text":"hi"}`, http.StatusCreated, 1},
+		{"DifferentBody", 7, "a", `{"text":"bye"}`, http.StatusUnprocessableEntity, 1},
+		{"OtherUser_SameKey", 8, "a", `{"text":"hi"}`, http.StatusCreated, 2},
+		{"NewKey", 7, "b", `{"text":"hi"}`, http.StatusCreated, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := do(tt.userId, tt.key, tt.body)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("expected the handler to have run %d times, got %d", tt.expectedCalls, calls)
+			}
+		})
+	}
+
+	t.Run("Retry_ReturnsStoredResponse", func(t *testing.T) {
+		rr := do(7, "a", `{"text":"hi"}`)
+		if rr.Body.String() != `{"ID":1}` || rr.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("expected the stored response, got %q %v", rr.Body, rr.Header())
+		}
+	})
+
+	t.Run("ServerError_NotStored", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		do(7, "c", `{}`)
+		status = http.StatusCreated
+		rr := do(7, "c", `{}`)
+		if rr.Code != http.StatusCreated || calls != 5 {
+			t.Errorf("expected the retry to run the handler again, got %v after %d calls", rr.Code, calls)
+		}
+	})
+}
+
+func TestMiddlewareAnonymous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewService(ctx, NewMockRepo(), DefaultTTL)
+
+	calls := 0
+	handler := Middleware(svc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	do("a", `{"username":"alice"}`)
+	if rr := do("a", `{"username":"alice"}`); rr.Header().Get("Idempotent-Replayed") != "true" || calls != 1 {
+		t.Errorf("expected the retry to be replayed, got %d calls", calls)
+	}
+	// another client that happens to pick the same key
+	if rr := do("a", `{"username":"bob"}`); rr.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("expected an unrelated request with the same key to be handled, got %v after %d calls", rr.Code, calls)
+	}
+}
+
+func TestMiddlewareInProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewService(ctx, NewMockRepo(), DefaultTTL)
+
+	if _, err := svc.Begin(ctx, 7, "a", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Begin(ctx, 7, "a", "hash"); err != ErrInProgress {
+		t.Errorf("expected ErrInProgress while the first request runs, got %v", err)
+	}
+
+	// a request that never finished gives up its key eventually
+	svc.now = func() time.Time { return time.Now().Add(2 * abandonAfter) }
+	if rec, err := svc.Begin(ctx, 7, "a", "hash"); rec != nil || err != nil {
+		t.Errorf("expected the abandoned key to be claimable, got %v %v", rec, err)
+	}
+}
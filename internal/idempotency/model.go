@@ -0,0 +1,19 @@
+package idempotency
+
+import "time"
+
+// Record is a request made with an Idempotency-Key. UserID is 0 for
+// anonymous requests such as registering, their Key is prefixed with the
+// RequestHash. Until Completed is set the
+// request is still being handled and the response fields are empty.
+type Record struct {
+	UserID int64 `gorm:"primaryKey"`
+	Key string `gorm:"primaryKey"`
+	RequestHash string
+	Completed bool
+	Status int
+	ContentType string
+	Body []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyRepo interface {
+	// InsertRecord stores rec unless its key is taken and reports whether it did.
+	InsertRecord(ctx context.Context, rec *Record) (bool, error)
+	GetRecord(ctx context.Context, userId int64, key string) (*Record, error)
+	CompleteRecord(ctx context.Context, rec *Record) error
+	DeleteRecord(ctx context.Context, userId int64, key string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+type idempotencyRepo struct {
+	db *gorm.DB
+}
+
+func NewRepo(db *gorm.DB) *idempotencyRepo {
+	return &idempotencyRepo{db: db}
+}
+
+func (r *idempotencyRepo) InsertRecord(ctx context.Context, rec *Record) (bool, error) {
+	res := gorm.WithResult()
+	if err := gorm.G[Record](r.db, res, clause.OnConflict{DoNothing: true}).Create(ctx, rec); err != nil {
+		log.Printf("could not insert idempotency key for userId=%d: %v", rec.UserID, err)
+		return false, err
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *idempotencyRepo) GetRecord(ctx context.Context, userId int64, key string) (*Record, error) {
+	rec, err := gorm.G[Record](r.db).Where("user_id = ? AND key = ?", userId, key).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *idempotencyRepo) CompleteRecord(ctx context.Context, rec *Record) error {
+	_, err := gorm.G[Record](r.db).Where("user_id = ? AND key = ?", rec.UserID, rec.Key).Select("completed", "status", "content_type", "body").Updates(ctx, *rec)
+	if err != nil {
+		log.Printf("could not store response for idempotency key of userId=%d: %v", rec.UserID, err)
+		return err
+	}
+	return nil
+}
+
+func (r *idempotencyRepo) DeleteRecord(ctx context.Context, userId int64, key string) error {
+	if _, err := gorm.G[Record](r.db).Where("user_id = ? AND key = ?", userId, key).Delete(ctx); err != nil {
+		log.Printf("could not delete idempotency key of userId=%d: %v", userId, err)
+		return err
+	}
+	return nil
+}
+
+func (r *idempotencyRepo) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	n, err := gorm.G[Record](r.db).Where("expires_at < ?", now).Delete(ctx)
+	if err != nil {
+		log.Printf("could not delete expired idempotency keys: %v", err)
+		return 0, err
+	}
+	return n, nil
+}
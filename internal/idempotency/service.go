@@ -0,0 +1,123 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultTTL = 24 * time.Hour
+
+	// abandonAfter is when a request that never completed, because the
+	// process died while handling it, no longer holds its key
+	abandonAfter = time.Minute
+	purgeInterval = time.Hour
+)
+
+var (
+	ErrKeyReused = errors.New("idempotency key was used for a different request")
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+type IdempotencyService interface {
+	// Begin claims key for a request. It returns the stored record when the
+	// same request already completed, and nil when the caller should handle
+	// the request and then Complete or Release the key.
+	Begin(ctx context.Context, userId int64, key, requestHash string) (*Record, error)
+	Complete(ctx context.Context, rec *Record) error
+	// Release gives up a claimed key so the request can be retried.
+	Release(ctx context.Context, userId int64, key string) error
+}
+
+type idempotencyService struct {
+	repo IdempotencyRepo
+	ttl time.Duration
+	now func() time.Time
+}
+
+func NewService(ctx context.Context, repo IdempotencyRepo, ttl time.Duration) *idempotencyService {
+	s := &idempotencyService{
+		repo: repo,
+		ttl: ttl,
+		now: time.Now,
+	}
+
+	go s.purge(ctx)
+	return s
+}
+
+func (s *idempotencyService) Begin(ctx context.Context, userId int64, key, requestHash string) (*Record, error) {
+	// one more round in case an expired record was in the way
+	for range 2 {
+		now := s.now()
+		inserted, err := s.repo.InsertRecord(ctx, &Record{
+			UserID: userId,
+			Key: key,
+			RequestHash: requestHash,
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.ttl),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if inserted {
+			return nil, nil
+		}
+
+		existing, err := s.repo.GetRecord(ctx, userId, key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		expired := existing.ExpiresAt.Before(now)
+		abandoned := !existing.Completed && existing.CreatedAt.Add(abandonAfter).Before(now)
+		if expired || abandoned {
+			if err := s.repo.DeleteRecord(ctx, userId, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if existing.RequestHash != requestHash {
+			return nil, ErrKeyReused
+		}
+		if !existing.Completed {
+			return nil, ErrInProgress
+		}
+		return existing, nil
+	}
+
+	return nil, ErrInProgress
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, rec *Record) error {
+	rec.Completed = true
+	return s.repo.CompleteRecord(ctx, rec)
+}
+
+func (s *idempotencyService) Release(ctx context.Context, userId int64, key string) error {
+	return s.repo.DeleteRecord(ctx, userId, key)
+}
+
+func (s *idempotencyService) purge(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.repo.DeleteExpired(ctx, s.now()); err == nil && n > 0 {
+				log.Printf("purged %d expired idempotency keys", n)
+			}
+		}
+	}
+}
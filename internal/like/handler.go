@@ -0,0 +1,143 @@
+package like
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/batch"
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultLikersLimit = 20
+	maxLikersLimit = 100
+)
+
+type LikeHandler struct {
+	svc LikeService
+	tweets tweet.TweetService
+	batcher *batch.Batcher[Op]
+}
+
+func NewHandler(ctx context.Context, svc LikeService, tweets tweet.TweetService) *LikeHandler {
+	h := &LikeHandler{
+		svc: svc,
+		tweets: tweets,
+	}
+
+	cfg := batch.DefaultConfig()
+	cfg.MaxBatchSize = 500
+	cfg.MaxWait = 100 * time.Millisecond
+	h.batcher = batch.New(ctx, cfg, h.flush)
+	return h
+}
+
+func (h *LikeHandler) Like(w http.ResponseWriter, r *http.Request) {
+	h.enqueue(w, r, false)
+}
+
+func (h *LikeHandler) Unlike(w http.ResponseWriter, r *http.Request) {
+	h.enqueue(w, r, true)
+}
+
+func (h *LikeHandler) enqueue(w http.ResponseWriter, r *http.Request, unlike bool) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.tweets.Exists(r.Context(), int64(tweetID))
+	if errors.Is(err, tweet.ErrTweetDeleted) && !unlike {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil && !errors.Is(err, tweet.ErrTweetDeleted) {
+		http.Error(w, "tweet not found", http.StatusNotFound)
+		return
+	}
+
+	op := Op{UserID: userId, TweetID: int64(tweetID), Unlike: unlike}
+	if _, err := h.batcher.Add(op); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Close stops accepting operations and waits until everything still
+// queued is applied. It returns how many operations were drained.
+func (h *LikeHandler) Close(ctx context.Context) (int, error) {
+	return h.batcher.Close(ctx)
+}
+
+// Wait blocks until the worker has stopped, see batch.Batcher.Wait.
+func (h *LikeHandler) Wait() {
+	h.batcher.Wait()
+}
+
+func (h *LikeHandler) GetLikes(w http.ResponseWriter, r *http.Request) {
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := defaultLikersLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	limit = min(limit, maxLikersLimit)
+
+	users, err := h.svc.GetLikers(r.Context(), int64(tweetID), limit, offset)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	out := map[string]interface{}{"users": users}
+	if len(users) == limit {
+		out["nextOffset"] = offset + limit
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+// flush applies a batch of operations for the batcher. Nobody waits for
+// their results, so a batch that cannot be applied is logged and dropped.
+func (h *LikeHandler) flush(ctx context.Context, ops []Op) []error {
+	err := h.svc.Apply(ctx, ops)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("dropped %d like operations: %v", len(ops), err)
+	errs := make([]error, len(ops))
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,23 @@
+package like
+
+import "time"
+
+type Like struct {
+	UserID int64 `json:"userId" gorm:"primaryKey"`
+	TweetID int64 `json:"tweetId,string" gorm:"primaryKey;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// LikeCount is the number of likes of a tweet, kept up to date by every
+// batch write so counts are never computed on read.
+type LikeCount struct {
+	TweetID int64 `gorm:"primaryKey;autoIncrement:false"`
+	Count int64 `gorm:"not null"`
+}
+
+// Op is a queued like or unlike waiting for the next batch write.
+type Op struct {
+	UserID int64
+	TweetID int64
+	Unlike bool
+}
@@ -0,0 +1,139 @@
+package like
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LikeRepo interface {
+	Apply(ctx context.Context, likes []Like, unlikes []Like) error
+	CountByTweets(ctx context.Context, tweetIds []int64) (map[int64]int64, error)
+	LikedBy(ctx context.Context, userId int64, tweetIds []int64) (map[int64]bool, error)
+	GetLikers(ctx context.Context, tweetId int64, limit, offset int) ([]int64, error)
+}
+
+type likeRepo struct {
+	db *gorm.DB
+}
+
+func NewRepo(db *gorm.DB) *likeRepo {
+	return &likeRepo{db: db}
+}
+
+// Apply stores a batch of likes and removes a batch of unlikes in a single
+// transaction, along with the like counts of their tweets. Liking twice or
+// unliking a tweet that was never liked is a no-op.
+func (r *likeRepo) Apply(ctx context.Context, likes []Like, unlikes []Like) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		delta := map[int64]int64{}
+
+		byTweet := map[int64][]Like{}
+		for _, l := range likes {
+			byTweet[l.TweetID] = append(byTweet[l.TweetID], l)
+		}
+		for tweetId, likes := range byTweet {
+			res := gorm.WithResult()
+			if err := gorm.G[Like](tx, res, clause.OnConflict{DoNothing: true}).CreateInBatches(ctx, &likes, len(likes)); err != nil {
+				return err
+			}
+			delta[tweetId] += res.RowsAffected
+		}
+
+		unlikedBy := map[int64][]int64{}
+		for _, l := range unlikes {
+			unlikedBy[l.TweetID] = append(unlikedBy[l.TweetID], l.UserID)
+		}
+		for tweetId, userIds := range unlikedBy {
+			n, err := gorm.G[Like](tx).Where("tweet_id = ? AND user_id IN ?", tweetId, userIds).Delete(ctx)
+			if err != nil {
+				return err
+			}
+			delta[tweetId] -= int64(n)
+		}
+
+		counts := []LikeCount{}
+		for tweetId, d := range delta {
+			if d != 0 {
+				counts = append(counts, LikeCount{TweetID: tweetId, Count: d})
+			}
+		}
+		if len(counts) == 0 {
+			return nil
+		}
+		// a fixed order keeps concurrent batches from deadlocking on the
+		// rows of the same tweets
+		slices.SortFunc(counts, func(a, b LikeCount) int {
+			return cmp.Compare(a.TweetID, b.TweetID)
+		})
+		add := clause.OnConflict{
+			Columns: []clause.Column{{Name: "tweet_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("like_counts.count + excluded.count")}),
+		}
+		return gorm.G[LikeCount](tx, add).CreateInBatches(ctx, &counts, len(counts))
+	})
+	if err != nil {
+		log.Printf("could not apply %d likes and %d unlikes: %v", len(likes), len(unlikes), err)
+		return err
+	}
+	return nil
+}
+
+func (r *likeRepo) CountByTweets(ctx context.Context, tweetIds []int64) (map[int64]int64, error) {
+	rows, err := gorm.G[LikeCount](r.db).Where("tweet_id IN ?", tweetIds).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch like counts of tweets %v: %v", tweetIds, err)
+		return nil, err
+	}
+
+	counts := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		counts[row.TweetID] = row.Count
+	}
+	return counts, nil
+}
+
+// Recount sets the like count of every liked tweet from the likes table. It
+// is only needed once, for likes stored before counts were kept.
+func (r *likeRepo) Recount(ctx context.Context) error {
+	err := r.db.WithContext(ctx).Exec(`INSERT INTO like_counts (tweet_id, count)
+		SELECT tweet_id, COUNT(*) FROM likes GROUP BY tweet_id
+		ON CONFLICT (tweet_id) DO UPDATE SET count = excluded.count`).Error
+	if err != nil {
+		log.Printf("could not recount likes: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *likeRepo) LikedBy(ctx context.Context, userId int64, tweetIds []int64) (map[int64]bool, error) {
+	likes, err := gorm.G[Like](r.db).Where("user_id = ? AND tweet_id IN ?", userId, tweetIds).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch likes of userId=%d: %v", userId, err)
+		return nil, err
+	}
+
+	liked := make(map[int64]bool, len(likes))
+	for _, l := range likes {
+		liked[l.TweetID] = true
+	}
+	return liked, nil
+}
+
+func (r *likeRepo) GetLikers(ctx context.Context, tweetId int64, limit, offset int) ([]int64, error) {
+	likes, err := gorm.G[Like](r.db).Where("tweet_id = ?", tweetId).Order("created_at DESC, user_id DESC").Limit(limit).Offset(offset).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch likers of tweet %d: %v", tweetId, err)
+		return nil, err
+	}
+
+	userIds := make([]int64, 0, len(likes))
+	for _, l := range likes {
+		userIds = append(userIds, l.UserID)
+	}
+	return userIds, nil
+}
@@ -0,0 +1,93 @@
+package like
+
+import (
+	"context"
+	"log"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+type LikeService interface {
+	Apply(ctx context.Context, ops []Op) error
+	GetLikers(ctx context.Context, tweetId int64, limit, offset int) ([]user.User, error)
+
+	// Decorate implements tweet.Decorator.
+	Decorate(ctx context.Context, tweets []tweet.Tweet) error
+}
+
+type likeService struct {
+	repo LikeRepo
+	users user.UserService
+}
+
+func NewService(repo LikeRepo, us user.UserService) *likeService {
+	return &likeService{
+		repo: repo,
+		users: us,
+	}
+}
+
+// Apply writes a batch of queued operations. When the same user liked and
+// unliked a tweet within one batch, only the latest operation counts.
+func (s *likeService) Apply(ctx context.Context, ops []Op) error {
+	type key struct{ userId, tweetId int64 }
+
+	latest := map[key]int{}
+	for i, op := range ops {
+		latest[key{op.UserID, op.TweetID}] = i
+	}
+
+	likes := []Like{}
+	unlikes := []Like{}
+	for i, op := range ops {
+		if latest[key{op.UserID, op.TweetID}] != i {
+			continue
+		}
+		l := Like{UserID: op.UserID, TweetID: op.TweetID}
+		if op.Unlike {
+			unlikes = append(unlikes, l)
+		} else {
+			likes = append(likes, l)
+		}
+	}
+
+	return s.repo.Apply(ctx, likes, unlikes)
+}
+
+func (s *likeService) GetLikers(ctx context.Context, tweetId int64, limit, offset int) ([]user.User, error) {
+	userIds, err := s.repo.GetLikers(ctx, tweetId, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.users.GetByIDs(ctx, userIds)
+}
+
+func (s *likeService) Decorate(ctx context.Context, tweets []tweet.Tweet) error {
+	tweetIds := make([]int64, 0, len(tweets))
+	for _, t := range tweets {
+		tweetIds = append(tweetIds, t.ID)
+	}
+
+	counts, err := s.repo.CountByTweets(ctx, tweetIds)
+	if err != nil {
+		log.Printf("could not count likes: %v", err)
+		return err
+	}
+
+	liked := map[int64]bool{}
+	if viewerId, ok := auth.UserIDFromContext(ctx); ok {
+		liked, err = s.repo.LikedBy(ctx, viewerId, tweetIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range tweets {
+		tweets[i].LikeCount = counts[tweets[i].ID]
+		tweets[i].LikedByMe = liked[tweets[i].ID]
+	}
+	return nil
+}
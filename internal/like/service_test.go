@@ -0,0 +1,116 @@
+package like
+
+import (
+	"context"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/tweet"
+)
+
+type mockRepo struct {
+	likes map[Like]bool
+}
+
+func NewMockRepo() *mockRepo {
+	return &mockRepo{
+		likes: map[Like]bool{},
+	}
+}
+
+func (r *mockRepo) Apply(ctx context.Context, likes []Like, unlikes []Like) error {
+	for _, l := range likes {
+		r.likes[l] = true
+	}
+	for _, l := range unlikes {
+		delete(r.likes, l)
+	}
+	return nil
+}
+
+func (r *mockRepo) CountByTweets(ctx context.Context, tweetIds []int64) (map[int64]int64, error) {
+	counts := map[int64]int64{}
+	for l := range r.likes {
+		counts[l.TweetID]++
+	}
+	return counts, nil
+}
+
+func (r *mockRepo) LikedBy(ctx context.Context, userId int64, tweetIds []int64) (map[int64]bool, error) {
+	liked := map[int64]bool{}
+	for l := range r.likes {
+		if l.UserID == userId {
+			liked[l.TweetID] = true
+		}
+	}
+	return liked, nil
+}
+
+func (r *mockRepo) GetLikers(ctx context.Context, tweetId int64, limit, offset int) ([]int64, error) {
+	return nil, nil
+}
+
+func TestServiceApplyKeepsLatestOp(t *testing.T) {
+	repo := NewMockRepo()
+	srv := NewService(repo, nil)
+
+	err := srv.Apply(context.Background(), []Op{
+		{UserID: 1, TweetID: 10},
+		{UserID: 2, TweetID: 10},
+		{UserID: 1, TweetID: 10, Unlike: true},
+		{UserID: 2, TweetID: 20, Unlike: true},
+		{UserID: 2, TweetID: 20},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[Like]bool{
+		{UserID: 2, TweetID: 10}: true,
+		{UserID: 2, TweetID: 20}: true,
+	}
+	if len(repo.likes) != len(want) {
+		t.Fatalf("expected likes %v got %v", want, repo.likes)
+	}
+	for l := range want {
+		if !repo.likes[l] {
+			t.Errorf("expected %+v to be liked", l)
+		}
+	}
+}
+
+func TestServiceDecorate(t *testing.T) {
+	repo := NewMockRepo()
+	repo.likes[Like{UserID: 1, TweetID: 10}] = true
+	repo.likes[Like{UserID: 2, TweetID: 10}] = true
+	repo.likes[Like{UserID: 2, TweetID: 20}] = true
+	srv := NewService(repo, nil)
+
+	tests := []struct{
+		name string
+		ctx context.Context
+		expectedLikedByMe map[int64]bool
+	}{
+		{"anonymous viewer", context.Background(), map[int64]bool{}},
+		{"viewer who liked one tweet", auth.WithUserID(context.Background(), 1), map[int64]bool{10: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tweets := []tweet.Tweet{{ID: 10}, {ID: 20}, {ID: 30}}
+			if err := srv.Decorate(tt.ctx, tweets); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedCounts := map[int64]int64{10: 2, 20: 1, 30: 0}
+			for _, tw := range tweets {
+				if tw.LikeCount != expectedCounts[tw.ID] {
+					t.Errorf("tweet %d: expected %d likes got %d", tw.ID, expectedCounts[tw.ID], tw.LikeCount)
+				}
+				if tw.LikedByMe != tt.expectedLikedByMe[tw.ID] {
+					t.Errorf("tweet %d: expected likedByMe=%v", tw.ID, tt.expectedLikedByMe[tw.ID])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/go-chi/chi"
+)
+
+// uploaded files are stored under their own key and never change, so they
+// can be cached for as long as clients like
+const cacheControl = "public, max-age=31536000, immutable"
+
+type MediaHandler struct {
+	svc MediaService
+}
+
+func NewHandler(svc MediaService) *MediaHandler {
+	return &MediaHandler{svc: svc}
+}
+
+func (h *MediaHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// leave room for the multipart envelope around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize + 1<<20)
+
+	file, _, err := r.FormFile("media")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "expected a multipart form with a media file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxUploadSize + 1))
+	if err != nil {
+		http.Error(w, "could not read upload", http.StatusBadRequest)
+		return
+	}
+
+	media, err := h.svc.Upload(r.Context(), userId, data)
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, ErrMalformedImage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(media)
+}
+
+func (h *MediaHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := strconv.Atoi(chi.URLParam(r, "mediaID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	media, blob, err := h.svc.Open(r.Context(), int64(mediaID))
+	if errors.Is(err, ErrMediaNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	// only media that still exists is confirmed unchanged, deleted media
+	// gets a 404 above like any other
+	etag := fmt.Sprintf(`"%d"`, mediaID)
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", media.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(media.Size, 10))
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
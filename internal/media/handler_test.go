@@ -0,0 +1,118 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/go-chi/chi"
+)
+
+func uploadRequest(t *testing.T, data []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("media", "upload.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req.WithContext(auth.WithUserID(req.Context(), 1))
+}
+
+func TestHandlerUpload(t *testing.T) {
+	srv, _ := newTestService(t)
+	handler := NewHandler(srv)
+
+	tests := []struct{
+		name string
+		data []byte
+		expectedStatus int
+	}{
+		{"Upload_Image", testPNG(t), http.StatusCreated},
+		{"Upload_NotAnImage", []byte("<html></html>"), http.StatusUnsupportedMediaType},
+		{"Upload_TooLarge", make([]byte, MaxUploadSize+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			handler.Upload(rr, uploadRequest(t, tt.data))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+		})
+	}
+}
+
+func TestHandlerServe(t *testing.T) {
+	srv, _ := newTestService(t)
+	handler := NewHandler(srv)
+
+	uploaded, err := srv.Upload(context.Background(), 1, testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{
+		name string
+		mediaID string
+		ifNoneMatch string
+		expectedStatus int
+	}{
+		{"Serve_Existing", "1", "", http.StatusOK},
+		{"Serve_NotModified", "1", `"1"`, http.StatusNotModified},
+		{"Serve_Changed", "1", `"2"`, http.StatusOK},
+		{"Serve_NonExisting", "2", "", http.StatusNotFound},
+		// a cached copy does not vouch for media that is gone
+		{"Serve_NonExistingNotModified", "2", `"2"`, http.StatusNotFound},
+		{"Serve_InvalidID", "abc", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("mediaID", tt.mediaID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+
+			rr := httptest.NewRecorder()
+
+			handler.Serve(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if rr.Code != http.StatusOK && rr.Code != http.StatusNotModified {
+				return
+			}
+			if got := rr.Header().Get("Cache-Control"); got != cacheControl {
+				t.Errorf("expected Cache-Control %q got %q", cacheControl, got)
+			}
+			if got := rr.Header().Get("ETag"); got != `"1"` {
+				t.Errorf("expected ETag %q got %q", `"1"`, got)
+			}
+			if rr.Code == http.StatusNotModified {
+				return
+			}
+			if rr.Header().Get("Content-Type") != "image/png" || rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("expected the image to be served as image/png with nosniff, got %v", rr.Header())
+			}
+			if int64(rr.Body.Len()) != uploaded.Size {
+				t.Errorf("expected %d bytes got %d", uploaded.Size, rr.Body.Len())
+			}
+		})
+	}
+}
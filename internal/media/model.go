@@ -0,0 +1,20 @@
+package media
+
+import (
+	"fmt"
+	"time"
+)
+
+type Media struct {
+	ID int64 `json:"id" gorm:"primaryKey"`
+	UserID int64 `json:"userId" gorm:"index"`
+	ContentType string `json:"contentType"`
+	Size int64 `json:"size"`
+	Key string `json:"-" gorm:"uniqueIndex"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL string `json:"url" gorm:"-"`
+}
+
+func (m *Media) setURL() {
+	m.URL = fmt.Sprintf("/media/%d", m.ID)
+}
@@ -0,0 +1,50 @@
+package media
+
+import (
+	"context"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+type MediaRepo interface {
+	InsertMedia(ctx context.Context, media *Media) error
+	GetMedia(ctx context.Context, mediaID int64) (*Media, error)
+	GetMany(ctx context.Context, mediaIDs []int64) ([]Media, error)
+}
+
+type mediaRepo struct {
+	db *gorm.DB
+}
+
+func NewRepo(db *gorm.DB) *mediaRepo {
+	return &mediaRepo{db: db}
+}
+
+func (r *mediaRepo) InsertMedia(ctx context.Context, media *Media) error {
+	if err := gorm.G[Media](r.db, gorm.WithResult()).Create(ctx, media); err != nil {
+		log.Printf("could not insert media for userId=%d: %v", media.UserID, err)
+		return err
+	}
+	return nil
+}
+
+func (r *mediaRepo) GetMedia(ctx context.Context, mediaID int64) (*Media, error) {
+	media, err := gorm.G[Media](r.db).Where("id = ?", mediaID).First(ctx)
+	if err != nil {
+		log.Printf("could not get media with ID %d: %v", mediaID, err)
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+func (r *mediaRepo) GetMany(ctx context.Context, mediaIDs []int64) ([]Media, error) {
+	media, err := gorm.G[Media](r.db).Where("id IN ?", mediaIDs).Find(ctx)
+	if err != nil {
+		log.Printf("could not get media %v: %v", mediaIDs, err)
+		return nil, err
+	}
+
+	return media, nil
+}
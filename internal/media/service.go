@@ -0,0 +1,132 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// MaxUploadSize is the largest file accepted by Upload, in bytes.
+const MaxUploadSize = 5 << 20
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type, must be a JPEG, PNG, GIF or WebP image")
+	ErrTooLarge = errors.New("media too large")
+	ErrMediaNotFound = errors.New("media not found")
+)
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png": true,
+	"image/gif": true,
+	"image/webp": true,
+}
+
+type MediaService interface {
+	Upload(ctx context.Context, userId int64, data []byte) (*Media, error)
+	Open(ctx context.Context, mediaID int64) (*Media, io.ReadCloser, error)
+	GetMany(ctx context.Context, mediaIDs []int64) ([]Media, error)
+}
+
+type mediaService struct {
+	repo MediaRepo
+	blobs BlobStore
+}
+
+func NewService(repo MediaRepo, blobs BlobStore) *mediaService {
+	return &mediaService{
+		repo: repo,
+		blobs: blobs,
+	}
+}
+
+// Upload checks the actual content of data rather than trusting the type
+// declared by the client, strips metadata and stores the result.
+func (s *mediaService) Upload(ctx context.Context, userId int64, data []byte) (*Media, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return nil, ErrUnsupportedType
+	}
+
+	data, err := StripMetadata(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		log.Printf("could not store blob for userId=%d: %v", userId, err)
+		return nil, err
+	}
+
+	media := &Media{
+		UserID: userId,
+		ContentType: contentType,
+		Size: int64(len(data)),
+		Key: key,
+	}
+	if err := s.repo.InsertMedia(ctx, media); err != nil {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("could not clean up blob %s: %v", key, err)
+		}
+		return nil, err
+	}
+
+	media.setURL()
+	return media, nil
+}
+
+func (s *mediaService) Open(ctx context.Context, mediaID int64) (*Media, io.ReadCloser, error) {
+	media, err := s.repo.GetMedia(ctx, mediaID)
+	if err != nil {
+		return nil, nil, ErrMediaNotFound
+	}
+
+	blob, err := s.blobs.Get(ctx, media.Key)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	media.setURL()
+	return media, blob, nil
+}
+
+func (s *mediaService) GetMany(ctx context.Context, mediaIDs []int64) ([]Media, error) {
+	if len(mediaIDs) == 0 {
+		return []Media{}, nil
+	}
+
+	media, err := s.repo.GetMany(ctx, mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range media {
+		media[i].setURL()
+	}
+	return media, nil
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating blob key: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
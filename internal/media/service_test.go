@@ -0,0 +1,125 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"io"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type mockRepo struct {
+	media map[int64]Media
+}
+
+func NewMockRepo() *mockRepo {
+	return &mockRepo{media: make(map[int64]Media)}
+}
+
+func (r *mockRepo) InsertMedia(ctx context.Context, media *Media) error {
+	media.ID = int64(len(r.media)) + 1
+	r.media[media.ID] = *media
+	return nil
+}
+
+func (r *mockRepo) GetMedia(ctx context.Context, mediaID int64) (*Media, error) {
+	media, ok := r.media[mediaID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &media, nil
+}
+
+func (r *mockRepo) GetMany(ctx context.Context, mediaIDs []int64) ([]Media, error) {
+	media := []Media{}
+	for _, id := range mediaIDs {
+		if m, ok := r.media[id]; ok {
+			media = append(media, m)
+		}
+	}
+	return media, nil
+}
+
+func testPNG(t *testing.T) []byte {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+func newTestService(t *testing.T) (*mediaService, *fsStore) {
+	blobs, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(NewMockRepo(), blobs), blobs
+}
+
+func TestServiceUpload(t *testing.T) {
+	img := testPNG(t)
+	tests := []struct{
+		name string
+		data []byte
+		expectedError error
+	}{
+		{"image", img, nil},
+		{"image at the limit", append(img, make([]byte, MaxUploadSize-len(img))...), nil},
+		{"too large", append(img, make([]byte, MaxUploadSize+1-len(img))...), ErrTooLarge},
+		// the content decides, not what the client says it is
+		{"text", []byte("hello"), ErrUnsupportedType},
+		{"HTML", []byte("<html><script>alert(1)</script></html>"), ErrUnsupportedType},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), ErrUnsupportedType},
+		{"PDF", []byte("%PDF-1.7\n"), ErrUnsupportedType},
+	}
+
+	srv, _ := newTestService(t)
+	for _, tt := range tests {
+		media, err := srv.Upload(context.Background(), 1, tt.data)
+		if !errors.Is(err, tt.expectedError) {
+			t.Errorf("%s: expected error %v got %v", tt.name, tt.expectedError, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if media.ContentType != "image/png" || media.UserID != 1 || media.URL == "" {
+			t.Errorf("%s: unexpected media %+v", tt.name, media)
+		}
+	}
+}
+
+func TestServiceOpen(t *testing.T) {
+	srv, blobs := newTestService(t)
+	ctx := context.Background()
+
+	uploaded, err := srv.Upload(ctx, 1, testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media, blob, err := srv.Open(ctx, uploaded.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if int64(len(data)) != media.Size || media.ContentType != "image/png" {
+		t.Errorf("expected the uploaded image, got %d bytes of %+v", len(data), media)
+	}
+
+	if _, _, err := srv.Open(ctx, uploaded.ID+1); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("expected %v for unknown media, got %v", ErrMediaNotFound, err)
+	}
+
+	// the row outlives its blob
+	if err := blobs.Delete(ctx, uploaded.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := srv.Open(ctx, uploaded.ID); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("expected %v for a deleted blob, got %v", ErrMediaNotFound, err)
+	}
+}
@@ -0,0 +1,147 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 32), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestStripJPEG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 52.5200 N 13.4050 E")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	data := append([]byte{}, encoded.Bytes()[:2]...)
+	data = append(data, segment...)
+	data = append(data, encoded.Bytes()[2:]...)
+
+	stripped, err := StripMetadata("image/jpeg", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("GPS")) {
+		t.Errorf("expected EXIF to be stripped")
+	}
+	if !bytes.Equal(stripped, encoded.Bytes()) {
+		t.Errorf("expected the image without the EXIF segment to be unchanged")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image does not decode: %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := func(chunkType string, data []byte) []byte {
+		c := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(c, uint32(len(data)))
+		c = append(c, chunkType...)
+		c = append(c, data...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+	}
+
+	// insert metadata chunks right after IHDR, which is 8+25 bytes in
+	afterIHDR := len(pngSignature) + 25
+	data := append([]byte{}, encoded.Bytes()[:afterIHDR]...)
+	data = append(data, chunk("eXIf", []byte("MM\x00*GPS"))...)
+	data = append(data, chunk("tEXt", []byte("Author\x00someone"))...)
+	data = append(data, encoded.Bytes()[afterIHDR:]...)
+
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("test image does not decode: %v", err)
+	}
+
+	stripped, err := StripMetadata("image/png", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(stripped, encoded.Bytes()) {
+		t.Errorf("expected metadata chunks to be stripped")
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	var encoded bytes.Buffer
+	// more than one frame gets the NETSCAPE2.0 loop extension
+	anim := &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}
+	if err := gif.EncodeAll(&encoded, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	// extensions may come anywhere between the frames, put them before the trailer
+	trailer := encoded.Len() - 1
+	data := append([]byte{}, encoded.Bytes()[:trailer]...)
+	data = append(data, 0x21, 0xFE, 7)
+	data = append(data, "GPS 52N"...)
+	data = append(data, 0)
+	data = append(data, 0x21, 0xFF, 11)
+	data = append(data, "XMP DataXMP"...)
+	data = append(data, 6)
+	data = append(data, "<xmp/>"...)
+	data = append(data, 0)
+	data = append(data, encoded.Bytes()[trailer:]...)
+
+	if _, err := gif.DecodeAll(bytes.NewReader(data)); err != nil {
+		t.Fatalf("test image does not decode: %v", err)
+	}
+
+	stripped, err := StripMetadata("image/gif", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(stripped, encoded.Bytes()) {
+		t.Errorf("expected the comment and XMP extensions to be stripped")
+	}
+	if !bytes.Contains(stripped, []byte("NETSCAPE2.0")) {
+		t.Errorf("expected the loop extension to be kept")
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	tests := []struct{
+		name string
+		contentType string
+		data []byte
+	}{
+		{"truncated JPEG", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10}},
+		{"not a JPEG", "image/jpeg", []byte("hello")},
+		{"truncated PNG", "image/png", append([]byte{}, pngSignature...)},
+		{"not a WebP", "image/webp", []byte("RIFF\x00\x00\x00\x00WAVE")},
+		{"truncated GIF", "image/gif", []byte("GIF89a\x08\x00\x08\x00\x00\x00\x00\x21\xFE\x05ab")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := StripMetadata(tt.contentType, tt.data); err != ErrMalformedImage {
+				t.Errorf("expected %v got %v", ErrMalformedImage, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package snowflake generates 64-bit IDs that sort by creation time. An ID
+// is 41 bits of milliseconds since Epoch, 10 bits of worker ID and 12 bits
+// of sequence, so up to 1024 workers can each hand out 4096 IDs per
+// millisecond without coordinating.
+package snowflake
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerBits = 10
+	sequenceBits = 12
+
+	MaxWorkerID = 1<<workerBits - 1
+	maxSequence = 1<<sequenceBits - 1
+
+	timeShift = workerBits + sequenceBits
+
+	// maxRegression is how far the clock may step back, e.g. after an NTP
+	// correction, before Next refuses to hand out IDs
+	maxRegression = 5 * time.Second
+)
+
+// Epoch is the zero time of the timestamp part.
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+type Generator struct {
+	mu sync.Mutex
+	workerID int64
+	// last is the timestamp of the last ID in milliseconds since Epoch
+	last int64
+	sequence int64
+	now func() time.Time
+}
+
+func New(workerID int64) (*Generator, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, fmt.Errorf("worker ID must be between 0 and %d", MaxWorkerID)
+	}
+	return &Generator{workerID: workerID, now: time.Now}, nil
+}
+
+// Next returns a new ID, greater than every ID returned before. If the
+// clock steps back a little, IDs keep counting up from the last timestamp
+// until it catches up again.
+func (g *Generator) Next() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(Epoch).Milliseconds()
+	if ms < g.last {
+		if time.Duration(g.last-ms)*time.Millisecond > maxRegression {
+			return 0, fmt.Errorf("%w by %v", ErrClockMovedBackwards, time.Duration(g.last-ms)*time.Millisecond)
+		}
+		ms = g.last
+	}
+
+	if ms == g.last {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// out of IDs for this millisecond, borrow the next one
+			ms++
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = ms
+
+	return ms<<timeShift | g.workerID<<sequenceBits | g.sequence, nil
+}
+
+// Time returns when id was generated, to the millisecond.
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>timeShift) * time.Millisecond)
+}
+
+func WorkerID(id int64) int64 {
+	return id >> sequenceBits & MaxWorkerID
+}
+
+func Sequence(id int64) int64 {
+	return id & maxSequence
+}
+
+// FromTime returns the smallest ID that can be generated at t, useful to
+// turn a point in time into a bound for ID ranges.
+func FromTime(t time.Time) int64 {
+	return t.Sub(Epoch).Milliseconds() << timeShift
+}
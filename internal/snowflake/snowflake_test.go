@@ -0,0 +1,91 @@
+package snowflake
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextIncreases(t *testing.T) {
+	g, err := New(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prev int64
+	for i := 0; i < 10000; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= prev {
+			t.Fatalf("expected IDs to increase, got %d after %d", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestDecode(t *testing.T) {
+	now := time.Date(2026, time.March, 4, 5, 6, 7, 8_000_000, time.UTC)
+	g, _ := New(42)
+	g.now = func() time.Time { return now }
+
+	first, _ := g.Next()
+	second, _ := g.Next()
+
+	if !Time(first).Equal(now) {
+		t.Errorf("expected time %v, got %v", now, Time(first))
+	}
+	if WorkerID(first) != 42 {
+		t.Errorf("expected worker 42, got %d", WorkerID(first))
+	}
+	if Sequence(first) != 0 || Sequence(second) != 1 {
+		t.Errorf("expected sequences 0 and 1, got %d and %d", Sequence(first), Sequence(second))
+	}
+	if FromTime(now) > first || FromTime(now.Add(time.Millisecond)) <= second {
+		t.Errorf("expected FromTime to bound the IDs of its millisecond")
+	}
+}
+
+func TestSequenceOverflow(t *testing.T) {
+	now := time.Now()
+	g, _ := New(1)
+	g.now = func() time.Time { return now }
+
+	var last int64
+	for i := 0; i <= maxSequence+1; i++ {
+		last, _ = g.Next()
+	}
+	if !Time(last).Equal(Time(FromTime(now)).Add(time.Millisecond)) {
+		t.Errorf("expected the ID after the last sequence to move to the next millisecond, got %v", Time(last))
+	}
+}
+
+func TestClockRegression(t *testing.T) {
+	now := time.Now()
+	g, _ := New(1)
+	g.now = func() time.Time { return now }
+
+	before, _ := g.Next()
+
+	// a small step back keeps IDs increasing
+	now = now.Add(-time.Second)
+	after, err := g.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after <= before {
+		t.Errorf("expected IDs to keep increasing, got %d after %d", after, before)
+	}
+
+	now = now.Add(-time.Minute)
+	if _, err := g.Next(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("expected ErrClockMovedBackwards, got %v", err)
+	}
+}
+
+func TestNewInvalidWorker(t *testing.T) {
+	if _, err := New(MaxWorkerID + 1); err == nil {
+		t.Errorf("expected an error for worker %d", MaxWorkerID+1)
+	}
+}
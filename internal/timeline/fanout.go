@@ -0,0 +1,191 @@
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+// backfillCount is how many of their recent tweets a newly followed user
+// brings onto the follower's timeline.
+const backfillCount = 200
+
+// FollowerLookup finds the followers of a user and who a user follows,
+// user.UserRepo satisfies it.
+type FollowerLookup interface {
+	GetFollowers(ctx context.Context, userId int64) ([]user.Follow, error)
+	GetFollows(ctx context.Context, userId int64) ([]user.Follow, error)
+}
+
+// UserLister pages through the IDs of all users, user.UserRepo satisfies it.
+type UserLister interface {
+	GetUserIDs(ctx context.Context, afterID int64, count int) ([]int64, error)
+}
+
+// TweetLookup finds the recent tweets of users, tweet.TweetRepo satisfies it.
+type TweetLookup interface {
+	GetTweetsFromUsers(ctx context.Context, userIds []int64, page tweet.Page) ([]tweet.Tweet, error)
+}
+
+// Fanout keeps a TimelineStore up to date. It is registered as a
+// tweet.Listener to push stored tweets to the timelines of their author and
+// the author's followers, and as a user.FollowListener to backfill and purge
+// timelines when follows change.
+//
+// It works on the repos rather than the services, which in turn are
+// constructed with the Fanout.
+type Fanout struct {
+	store TimelineStore
+	followers FollowerLookup
+	tweets TweetLookup
+	// pull, if set, picks the authors that are only put on their own
+	// timeline, TimelineService pulls them in for their followers
+	pull *PullPolicy
+}
+
+func NewFanout(store TimelineStore, followers FollowerLookup, tweets TweetLookup, pull *PullPolicy) *Fanout {
+	return &Fanout{
+		store: store,
+		followers: followers,
+		tweets: tweets,
+		pull: pull,
+	}
+}
+
+func (f *Fanout) isPulled(ctx context.Context, userId int64) bool {
+	if f.pull == nil {
+		return false
+	}
+
+	pulled, err := f.pull.IsPulled(ctx, userId)
+	if err != nil {
+		// pushing too much is cheaper to recover from than missing tweets
+		log.Printf("could not tell whether userId=%d is pulled: %v", userId, err)
+		return false
+	}
+	return pulled
+}
+
+// Stored puts tweets on the timelines of their authors and followers. It
+// fails as a whole so the tweets are passed in again, entries that made it
+// already are skipped then.
+func (f *Fanout) Stored(ctx context.Context, tweets []tweet.Tweet) error {
+	byAuthor := map[int64][]int64{}
+	for _, t := range tweets {
+		byAuthor[t.UserID] = append(byAuthor[t.UserID], t.ID)
+	}
+
+	entries := []TimelineEntry{}
+	for authorId, tweetIDs := range byAuthor {
+		userIds := []int64{authorId}
+		if !f.isPulled(ctx, authorId) {
+			followers, err := f.followers.GetFollowers(ctx, authorId)
+			if err != nil {
+				log.Printf("could not fan out %d tweets of userId=%d: %v", len(tweetIDs), authorId, err)
+				return err
+			}
+			for _, follow := range followers {
+				userIds = append(userIds, follow.FollowerID)
+			}
+		}
+		for _, userId := range userIds {
+			for _, tweetID := range tweetIDs {
+				entries = append(entries, TimelineEntry{UserID: userId, TweetID: tweetID, AuthorID: authorId})
+			}
+		}
+	}
+
+	if err := f.store.Append(ctx, entries); err != nil {
+		log.Printf("could not fan out %d tweets: %v", len(tweets), err)
+		return err
+	}
+	return nil
+}
+
+func (f *Fanout) Followed(ctx context.Context, followerId, followedId int64) {
+	if f.isPulled(ctx, followedId) {
+		return
+	}
+
+	entries, err := f.recent(ctx, followerId, followedId)
+	if err == nil {
+		err = f.store.Append(ctx, entries)
+	}
+	if err != nil {
+		log.Printf("could not backfill the timeline of userId=%d with userId=%d: %v", followerId, followedId, err)
+	}
+}
+
+// recent returns the entries that put the recent tweets of authorId on the
+// timeline of userId.
+func (f *Fanout) recent(ctx context.Context, userId, authorId int64) ([]TimelineEntry, error) {
+	tweets, err := f.tweets.GetTweetsFromUsers(ctx, []int64{authorId}, tweet.Page{Count: backfillCount})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TimelineEntry, len(tweets))
+	for i, t := range tweets {
+		entries[i] = TimelineEntry{UserID: userId, TweetID: t.ID, AuthorID: authorId}
+	}
+	return entries, nil
+}
+
+// Backfill puts the recent tweets of userId and of everyone they follow on
+// their timeline, the way following them one by one would have.
+func (f *Fanout) Backfill(ctx context.Context, userId int64) error {
+	follows, err := f.followers.GetFollows(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	authors := []int64{userId}
+	for _, follow := range follows {
+		if follow.FollowedID != userId && !f.isPulled(ctx, follow.FollowedID) {
+			authors = append(authors, follow.FollowedID)
+		}
+	}
+
+	entries := []TimelineEntry{}
+	for _, authorId := range authors {
+		recent, err := f.recent(ctx, userId, authorId)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, recent...)
+	}
+	return f.store.Append(ctx, entries)
+}
+
+// BackfillAll backfills the timeline of every user, for a store that starts
+// out empty while tweets and follows are already there. It returns how many
+// timelines were filled.
+func (f *Fanout) BackfillAll(ctx context.Context, users UserLister) (int, error) {
+	filled := 0
+	var afterID int64
+	for {
+		ids, err := users.GetUserIDs(ctx, afterID, 100)
+		if err != nil {
+			return filled, err
+		}
+		if len(ids) == 0 {
+			return filled, nil
+		}
+		for _, id := range ids {
+			if err := f.Backfill(ctx, id); err != nil {
+				return filled, fmt.Errorf("backfilling userId=%d: %w", id, err)
+			}
+			filled++
+		}
+		afterID = ids[len(ids)-1]
+	}
+}
+
+func (f *Fanout) Unfollowed(ctx context.Context, followerId, followedId int64) {
+	if err := f.store.Purge(ctx, followerId, followedId); err != nil {
+		log.Printf("could not purge userId=%d from the timeline of userId=%d: %v", followedId, followerId, err)
+	}
+}
@@ -0,0 +1,150 @@
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+type mockFollowers map[int64][]int64
+
+func (m mockFollowers) GetFollowers(ctx context.Context, userId int64) ([]user.Follow, error) {
+	follows := []user.Follow{}
+	for _, id := range m[userId] {
+		follows = append(follows, user.Follow{FollowerID: id, FollowedID: userId})
+	}
+	return follows, nil
+}
+
+func (m mockFollowers) GetFollows(ctx context.Context, userId int64) ([]user.Follow, error) {
+	follows := []user.Follow{}
+	for followedId, followers := range m {
+		if slices.Contains(followers, userId) {
+			follows = append(follows, user.Follow{FollowerID: userId, FollowedID: followedId})
+		}
+	}
+	return follows, nil
+}
+
+func (m mockFollowers) CountFollowers(ctx context.Context, userIds []int64) (map[int64]int64, error) {
+	counts := map[int64]int64{}
+	for _, id := range userIds {
+		if n := len(m[id]); n > 0 {
+			counts[id] = int64(n)
+		}
+	}
+	return counts, nil
+}
+
+type mockTweetLookup []tweet.Tweet
+
+func (m mockTweetLookup) GetTweetsFromUsers(ctx context.Context, userIds []int64, page tweet.Page) ([]tweet.Tweet, error) {
+	out := []tweet.Tweet{}
+	for _, t := range m {
+		if slices.Contains(userIds, t.UserID) && page.Contains(t.ID) {
+			out = append(out, t)
+		}
+	}
+	return page.Trim(out), nil
+}
+
+func TestFanout(t *testing.T) {
+	store := NewMemoryStore()
+	tweets := mockTweetLookup{{ID: 2, UserID: 3}, {ID: 1, UserID: 3}}
+	fanout := NewFanout(store, mockFollowers{1: {2}}, tweets, nil)
+	ctx := context.Background()
+
+	timeline := func(userId int64) []int64 {
+		ids, _ := store.Range(ctx, userId, tweet.Page{Count: 10})
+		return ids
+	}
+
+	fanout.Stored(ctx, []tweet.Tweet{{ID: 10, UserID: 1}, {ID: 11, UserID: 1}})
+	if got := timeline(1); !slices.Equal(got, []int64{11, 10}) {
+		t.Errorf("expected the author's own tweets on their timeline, got %v", got)
+	}
+	if got := timeline(2); !slices.Equal(got, []int64{11, 10}) {
+		t.Errorf("expected the tweets on the follower's timeline, got %v", got)
+	}
+	if got := timeline(3); len(got) != 0 {
+		t.Errorf("expected nothing on the timeline of someone else, got %v", got)
+	}
+
+	fanout.Followed(ctx, 2, 3)
+	if got := timeline(2); !slices.Equal(got, []int64{11, 10, 2, 1}) {
+		t.Errorf("expected the followed user's tweets to be backfilled, got %v", got)
+	}
+
+	fanout.Unfollowed(ctx, 2, 1)
+	if got := timeline(2); !slices.Equal(got, []int64{2, 1}) {
+		t.Errorf("expected the unfollowed user's tweets to be purged, got %v", got)
+	}
+}
+
+func TestFanoutPull(t *testing.T) {
+	store := NewMemoryStore()
+	followers := mockFollowers{1: {2}, 3: {2, 4, 5}}
+	tweets := mockTweetLookup{{ID: 1, UserID: 3}}
+	fanout := NewFanout(store, followers, tweets, NewPullPolicy(2, followers, store))
+	ctx := context.Background()
+
+	timeline := func(userId int64) []int64 {
+		ids, _ := store.Range(ctx, userId, tweet.Page{Count: 10})
+		return ids
+	}
+
+	fanout.Stored(ctx, []tweet.Tweet{{ID: 10, UserID: 1}, {ID: 11, UserID: 3}})
+	if got := timeline(2); !slices.Equal(got, []int64{10}) {
+		t.Errorf("expected only the tweet of the author below the threshold, got %v", got)
+	}
+	if got := timeline(3); !slices.Equal(got, []int64{11}) {
+		t.Errorf("expected the pulled author's tweet on their own timeline, got %v", got)
+	}
+
+	fanout.Followed(ctx, 6, 3)
+	if got := timeline(6); len(got) != 0 {
+		t.Errorf("expected no backfill from a pulled author, got %v", got)
+	}
+}
+
+type mockUserLister []int64
+
+func (m mockUserLister) GetUserIDs(ctx context.Context, afterID int64, count int) ([]int64, error) {
+	ids := []int64{}
+	for _, id := range m {
+		if id > afterID && len(ids) < count {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func TestFanoutBackfillAll(t *testing.T) {
+	store := NewMemoryStore()
+	followers := mockFollowers{1: {2}, 3: {2}, 4: {2, 5, 6}}
+	tweets := mockTweetLookup{{ID: 4, UserID: 4}, {ID: 3, UserID: 3}, {ID: 2, UserID: 2}, {ID: 1, UserID: 1}}
+	fanout := NewFanout(store, followers, tweets, NewPullPolicy(2, followers, store))
+	ctx := context.Background()
+
+	filled, err := fanout.BackfillAll(ctx, mockUserLister{1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filled != 4 {
+		t.Errorf("expected 4 timelines to be filled, got %d", filled)
+	}
+
+	timeline := func(userId int64) []int64 {
+		ids, _ := store.Range(ctx, userId, tweet.Page{Count: 10})
+		return ids
+	}
+	if got := timeline(2); !slices.Equal(got, []int64{3, 2, 1}) {
+		t.Errorf("expected the user's own and followed tweets without the pulled author, got %v", got)
+	}
+	if got := timeline(4); !slices.Equal(got, []int64{4}) {
+		t.Errorf("expected the pulled author's tweets on their own timeline, got %v", got)
+	}
+}
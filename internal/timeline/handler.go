@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/tweet"
 )
 
 type TimelineHandler struct {
@@ -22,12 +23,18 @@ func (h *TimelineHandler) GetTweets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tweets, err := h.svc.GetTweets(r.Context(), userId)
+	page, err := tweet.PageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeline, err := h.svc.GetTweets(r.Context(), userId, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(tweets)
+	json.NewEncoder(w).Encode(timeline)
 }
\ No newline at end of file
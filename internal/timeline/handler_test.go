@@ -0,0 +1,61 @@
+package timeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/tweet"
+)
+
+func TestHandlerGetTweets(t *testing.T) {
+	tweets := &mockTweetService{tweets: []tweet.Tweet{{ID: 1, UserID: 1}}}
+	handler := NewHandler(NewService(tweets, &mockUserService{}))
+
+	tests := []struct{
+		name string
+		query string
+		expectedStatus int
+	}{
+		{"GetTweets_Default", "", http.StatusOK},
+		{"GetTweets_Page", "?max_id=10&count=5", http.StatusOK},
+		{"GetTweets_InvalidCount", "?count=abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/" + tt.query, nil)
+			req = req.WithContext(auth.WithUserID(req.Context(), 1))
+			rr := httptest.NewRecorder()
+
+			handler.GetTweets(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("wrong response code, got %v want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	handler.GetTweets(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected anonymous requests to be rejected, got %v", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(auth.WithUserID(req.Context(), 1))
+	rr = httptest.NewRecorder()
+	handler.GetTweets(rr, req)
+
+	var out tweet.FeedPage
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if got := ids(out.Tweets); !slices.Equal(got, []int64{1}) {
+		t.Errorf("expected the viewer's own tweet, got %v", got)
+	}
+}
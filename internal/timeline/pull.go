@@ -0,0 +1,155 @@
+package timeline
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// followerCountTTL is how long a follower count is trusted before it is
+// counted again.
+const followerCountTTL = time.Minute
+
+// FollowerCounter counts followers, user.UserRepo satisfies it.
+type FollowerCounter interface {
+	CountFollowers(ctx context.Context, userIds []int64) (map[int64]int64, error)
+}
+
+// PulledStore remembers which authors are pulled, every TimelineStore is
+// one.
+type PulledStore interface {
+	// GetPulled returns the authors of userIds that are pulled.
+	GetPulled(ctx context.Context, userIds []int64) ([]int64, error)
+	// AddPulled records userIds as pulled, ones already there are skipped.
+	AddPulled(ctx context.Context, userIds []int64) error
+}
+
+type pullStatus struct {
+	pulled bool
+	at time.Time
+}
+
+// PullPolicy picks the authors with more than threshold followers. Their
+// tweets are not fanned out to every follower, which would take a write per
+// follower for each tweet, but pulled in when a timeline is read.
+//
+// Once an author crosses the threshold they stay pulled for good, even if
+// they drop back below it, so nothing they posted in the meantime and none
+// of the follows they gained go missing from timelines. Tweets posted
+// before crossing stay on the timelines they were fanned out to, and are
+// deduplicated against the pulled ones on read.
+type PullPolicy struct {
+	threshold int64
+	followers FollowerCounter
+	pulled PulledStore
+
+	// statuses caches whether authors are pulled for followerCountTTL,
+	// expired ones are swept out every followerCountTTL
+	mu sync.Mutex
+	statuses map[int64]pullStatus
+	swept time.Time
+	now func() time.Time
+}
+
+func NewPullPolicy(threshold int64, followers FollowerCounter, pulled PulledStore) *PullPolicy {
+	return &PullPolicy{
+		threshold: threshold,
+		followers: followers,
+		pulled: pulled,
+		statuses: map[int64]pullStatus{},
+		now: time.Now,
+	}
+}
+
+// Pulled returns the authors of userIds whose tweets are pulled.
+func (p *PullPolicy) Pulled(ctx context.Context, userIds []int64) ([]int64, error) {
+	now := p.now()
+
+	p.mu.Lock()
+	p.sweep(now)
+	stale := []int64{}
+	for _, id := range userIds {
+		if s, ok := p.statuses[id]; !ok || now.Sub(s.at) > followerCountTTL {
+			stale = append(stale, id)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(stale) > 0 {
+		pulled, err := p.refresh(ctx, stale)
+		if err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		for _, id := range stale {
+			p.statuses[id] = pullStatus{pulled: slices.Contains(pulled, id), at: now}
+		}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pulled := []int64{}
+	for _, id := range userIds {
+		if p.statuses[id].pulled {
+			pulled = append(pulled, id)
+		}
+	}
+	return pulled, nil
+}
+
+// refresh finds the pulled authors of userIds, recording the ones that
+// crossed the threshold since they were last looked at.
+func (p *PullPolicy) refresh(ctx context.Context, userIds []int64) ([]int64, error) {
+	pulled, err := p.pulled.GetPulled(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	unpulled := slices.DeleteFunc(slices.Clone(userIds), func(id int64) bool {
+		return slices.Contains(pulled, id)
+	})
+	if len(unpulled) == 0 {
+		return pulled, nil
+	}
+
+	counts, err := p.followers.CountFollowers(ctx, unpulled)
+	if err != nil {
+		return nil, err
+	}
+
+	crossed := []int64{}
+	for _, id := range unpulled {
+		if counts[id] > p.threshold {
+			crossed = append(crossed, id)
+		}
+	}
+	if len(crossed) > 0 {
+		if err := p.pulled.AddPulled(ctx, crossed); err != nil {
+			return nil, err
+		}
+	}
+	return append(pulled, crossed...), nil
+}
+
+// sweep drops expired statuses, so the cache only holds the authors seen
+// lately.
+func (p *PullPolicy) sweep(now time.Time) {
+	if now.Sub(p.swept) < followerCountTTL {
+		return
+	}
+	for id, s := range p.statuses {
+		if now.Sub(s.at) > followerCountTTL {
+			delete(p.statuses, id)
+		}
+	}
+	p.swept = now
+}
+
+// IsPulled reports whether the tweets of userId are pulled.
+func (p *PullPolicy) IsPulled(ctx context.Context, userId int64) (bool, error) {
+	pulled, err := p.Pulled(ctx, []int64{userId})
+	return len(pulled) > 0, err
+}
@@ -0,0 +1,248 @@
+package timeline
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+type countingFollowers struct {
+	mockFollowers
+	calls int
+}
+
+func (c *countingFollowers) CountFollowers(ctx context.Context, userIds []int64) (map[int64]int64, error) {
+	c.calls++
+	return c.mockFollowers.CountFollowers(ctx, userIds)
+}
+
+func TestPullPolicy(t *testing.T) {
+	followers := &countingFollowers{mockFollowers: mockFollowers{1: {2}, 3: {1, 2, 4}}}
+	pull := NewPullPolicy(2, followers, NewMemoryStore())
+	now := time.Now()
+	pull.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	pulled, err := pull.Pulled(ctx, []int64{1, 2, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(pulled, []int64{3}) {
+		t.Errorf("expected only the user above the threshold, got %v", pulled)
+	}
+
+	pull.Pulled(ctx, []int64{3})
+	if followers.calls != 1 {
+		t.Errorf("expected follower counts to be cached, got %d calls", followers.calls)
+	}
+
+	// 1 gained followers, which shows once the cached count expires
+	followers.mockFollowers[1] = []int64{2, 3, 4}
+	now = now.Add(followerCountTTL + time.Second)
+	if pulled, _ := pull.Pulled(ctx, []int64{1}); !slices.Equal(pulled, []int64{1}) {
+		t.Errorf("expected the count to be refreshed, got %v", pulled)
+	}
+	if followers.calls != 2 {
+		t.Errorf("expected a second count, got %d calls", followers.calls)
+	}
+}
+
+func TestPullPolicySticky(t *testing.T) {
+	followers := &countingFollowers{mockFollowers: mockFollowers{3: {1, 2, 4}}}
+	store := NewMemoryStore()
+	pull := NewPullPolicy(2, followers, store)
+	now := time.Now()
+	pull.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if pulled, _ := pull.IsPulled(ctx, 3); !pulled {
+		t.Fatal("expected the user above the threshold to be pulled")
+	}
+
+	// dropping back below the threshold would leave what 3 posted while
+	// pulled off the timelines of their followers
+	followers.mockFollowers[3] = []int64{1}
+	now = now.Add(followerCountTTL + time.Second)
+	if pulled, _ := pull.IsPulled(ctx, 3); !pulled {
+		t.Error("expected the user to stay pulled")
+	}
+	if followers.calls != 1 {
+		t.Errorf("expected a pulled user not to be counted again, got %d calls", followers.calls)
+	}
+
+	// a new process goes by what the store remembers
+	restarted := NewPullPolicy(2, followers, store)
+	if pulled, _ := restarted.IsPulled(ctx, 3); !pulled {
+		t.Error("expected the user to stay pulled after a restart")
+	}
+}
+
+func TestPullPolicySweep(t *testing.T) {
+	followers := mockFollowers{}
+	pull := NewPullPolicy(2, followers, NewMemoryStore())
+	now := time.Now()
+	pull.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for id := int64(1); id <= 100; id++ {
+		pull.IsPulled(ctx, id)
+	}
+	now = now.Add(followerCountTTL + time.Second)
+	pull.IsPulled(ctx, 1000)
+
+	if n := len(pull.statuses); n != 1 {
+		t.Errorf("expected the expired statuses to be swept, %d left", n)
+	}
+}
+
+// celebrityGraph is a synthetic follow graph of users 1..users. The first
+// celebrities users are followed by everyone, the others each follow
+// follows random users.
+func celebrityGraph(users, celebrities, follows int) (mockFollowers, map[int64][]int64) {
+	r := rand.New(rand.NewSource(1))
+	followers := mockFollowers{}
+	following := map[int64][]int64{}
+	for u := int64(1); u <= int64(users); u++ {
+		targets := map[int64]bool{}
+		for c := int64(1); c <= int64(celebrities); c++ {
+			targets[c] = true
+		}
+		for len(targets) < celebrities+follows {
+			targets[int64(r.Intn(users))+1] = true
+		}
+		delete(targets, u)
+		for target := range targets {
+			followers[target] = append(followers[target], u)
+			following[u] = append(following[u], target)
+		}
+	}
+	return followers, following
+}
+
+// benchTweets stands in for the tweets table with an index on the author,
+// so pulling is about as cheap as the keyset query would be.
+type benchTweets struct {
+	tweet.TweetService
+	byID map[int64]tweet.Tweet
+	// byUser holds the tweets of each user, newest first
+	byUser map[int64][]tweet.Tweet
+}
+
+func (s *benchTweets) add(t tweet.Tweet) {
+	s.byID[t.ID] = t
+	s.byUser[t.UserID] = append([]tweet.Tweet{t}, s.byUser[t.UserID]...)
+}
+
+func (s *benchTweets) GetByIDs(ctx context.Context, tweetIDs []int64) ([]tweet.Tweet, error) {
+	out := make([]tweet.Tweet, 0, len(tweetIDs))
+	for _, id := range tweetIDs {
+		if t, ok := s.byID[id]; ok {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (s *benchTweets) GetFromUsers(ctx context.Context, userIds []int64, page tweet.Page) ([]tweet.Tweet, error) {
+	out := []tweet.Tweet{}
+	for _, id := range userIds {
+		n := 0
+		for _, t := range s.byUser[id] {
+			if page.Contains(t.ID) {
+				out = append(out, t)
+				if n++; n == page.Count {
+					break
+				}
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b tweet.Tweet) int { return cmp.Compare(b.ID, a.ID) })
+	return page.Trim(out), nil
+}
+
+func (s *benchTweets) MergePending(ctx context.Context, tweets []tweet.Tweet, page tweet.Page) []tweet.Tweet {
+	return tweets
+}
+
+type benchUsers struct {
+	user.UserService
+	following map[int64][]int64
+}
+
+func (s *benchUsers) GetFollows(ctx context.Context, userId int64) ([]user.Follow, error) {
+	follows := make([]user.Follow, len(s.following[userId]))
+	for i, id := range s.following[userId] {
+		follows[i] = user.Follow{FollowerID: userId, FollowedID: id}
+	}
+	return follows, nil
+}
+
+// BenchmarkCelebrityGraph posts and reads tweets on a graph of 10000 users
+// where 10 celebrities are followed by everyone, once fanning out every
+// author and once pulling authors with more than 1000 followers.
+func BenchmarkCelebrityGraph(b *testing.B) {
+	const users, celebrities = 10000, 10
+	followers, following := celebrityGraph(users, celebrities, 50)
+
+	for _, threshold := range []int64{0, 1000} {
+		name := "push"
+		if threshold > 0 {
+			name = fmt.Sprintf("pull>%d", threshold)
+		}
+
+		setup := func() (*Fanout, *timelineService, *benchTweets) {
+			store := NewMemoryStore()
+			tweets := &benchTweets{byID: map[int64]tweet.Tweet{}, byUser: map[int64][]tweet.Tweet{}}
+			opts := []Option{WithStore(store)}
+			var pull *PullPolicy
+			if threshold > 0 {
+				pull = NewPullPolicy(threshold, followers, store)
+				opts = append(opts, WithPull(pull))
+			}
+			fanout := NewFanout(store, followers, nil, pull)
+			return fanout, NewService(tweets, &benchUsers{following: following}, opts...), tweets
+		}
+
+		// every 100th tweet is by a celebrity
+		author := func(i int) int64 {
+			if i%100 == 0 {
+				return int64(i/100%celebrities) + 1
+			}
+			return int64(i%(users-celebrities)) + celebrities + 1
+		}
+
+		b.Run(name+"/post", func(b *testing.B) {
+			fanout, _, _ := setup()
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fanout.Stored(ctx, []tweet.Tweet{{ID: int64(i) + 1, UserID: author(i)}})
+			}
+		})
+
+		b.Run(name+"/read", func(b *testing.B) {
+			fanout, srv, tweets := setup()
+			ctx := context.Background()
+			for i := 0; i < 20000; i++ {
+				t := tweet.Tweet{ID: int64(i) + 1, UserID: author(i)}
+				tweets.add(t)
+				fanout.Stored(ctx, []tweet.Tweet{t})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				userId := int64(i%users) + 1
+				if _, err := srv.GetTweets(auth.WithUserID(ctx, userId), userId, tweet.Page{Count: 20}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,44 +3,204 @@ package timeline
 import (
 	"context"
 	"log"
+	"slices"
 
 	"github.com/daniiltsioma/twitter/internal/tweet"
 	"github.com/daniiltsioma/twitter/internal/user"
 )
 
 type TimelineService interface {
-	GetTweets(ctx context.Context, userId int64) ([]tweet.Tweet, error)
+	GetTweets(ctx context.Context, userId int64, page tweet.Page) (*tweet.FeedPage, error)
+}
+
+type Option func(*timelineService)
+
+// WithStore reads timelines from store, which a Fanout keeps up to date,
+// instead of querying the tweets of everyone the user follows.
+func WithStore(store TimelineStore) Option {
+	return func(s *timelineService) {
+		s.store = store
+	}
+}
+
+// WithPull pulls the tweets of the authors picked by pull in when a
+// timeline is read from the store, since the Fanout left them out. It has
+// to be the same policy the Fanout was given.
+func WithPull(pull *PullPolicy) Option {
+	return func(s *timelineService) {
+		s.pull = pull
+	}
 }
 
 type timelineService struct {
 	tweets tweet.TweetService
 	users user.UserService
+	store TimelineStore
+	pull *PullPolicy
 }
 
-func NewService(ts tweet.TweetService, us user.UserService) *timelineService {
-	return &timelineService{
+func NewService(ts tweet.TweetService, us user.UserService, opts ...Option) *timelineService {
+	s := &timelineService{
 		tweets: ts,
 		users: us,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *timelineService) GetTweets(ctx context.Context, userId int64) ([]tweet.Tweet, error) {
+func (s *timelineService) GetTweets(ctx context.Context, userId int64, page tweet.Page) (*tweet.FeedPage, error) {
+	if s.store != nil {
+		return s.getStored(ctx, userId, page)
+	}
+
 	follows, err := s.users.GetFollows(ctx, userId)
 	if err != nil {
 		log.Printf("users error: %v", err)
 		return nil, err
 	}
 
-	userIds := []int64{}
+	// users see their own tweets too, which also keeps the list from
+	// being empty for someone who follows nobody
+	userIds := []int64{userId}
 	for _, f := range follows {
-		userIds = append(userIds, f.FollowedID)
+		if f.FollowedID != userId {
+			userIds = append(userIds, f.FollowedID)
+		}
 	}
 
-	tweets, err := s.tweets.GetFromUsers(ctx, userIds)
+	tweets, err := s.tweets.GetFromUsers(ctx, userIds, page)
 	if err != nil {
 		log.Printf("tweets error: %v", err)
 		return nil, err
 	}
 
-	return tweets, err
-}
\ No newline at end of file
+	return paginate(tweet.IDs(tweets), tweets, page), nil
+}
+
+// getStored reads the timeline from the store and fills in the tweets.
+func (s *timelineService) getStored(ctx context.Context, userId int64, page tweet.Page) (*tweet.FeedPage, error) {
+	ids, err := s.store.Range(ctx, userId, page)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets, err := s.tweets.GetByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("tweets error: %v", err)
+		return nil, err
+	}
+
+	if s.pull != nil {
+		pulled, err := s.getPulled(ctx, userId, page)
+		if err != nil {
+			return nil, err
+		}
+		ids, tweets = mergePulled(ids, tweets, pulled, page)
+	}
+
+	// the viewer's own tweets still in the queue have not been fanned out
+	tweets = s.tweets.MergePending(ctx, tweets, page)
+	return paginate(ids, tweets, page), nil
+}
+
+// getPulled reads the page of tweets by the followed authors that are not
+// fanned out.
+func (s *timelineService) getPulled(ctx context.Context, userId int64, page tweet.Page) ([]tweet.Tweet, error) {
+	follows, err := s.users.GetFollows(ctx, userId)
+	if err != nil {
+		log.Printf("users error: %v", err)
+		return nil, err
+	}
+
+	followed := make([]int64, 0, len(follows))
+	for _, f := range follows {
+		followed = append(followed, f.FollowedID)
+	}
+	if len(followed) == 0 {
+		return nil, nil
+	}
+
+	pulled, err := s.pull.Pulled(ctx, followed)
+	if err != nil {
+		log.Printf("could not count followers: %v", err)
+		return nil, err
+	}
+	if len(pulled) == 0 {
+		return nil, nil
+	}
+
+	return s.tweets.GetFromUsers(ctx, pulled, page)
+}
+
+// mergePulled merges the pulled tweets into a page read from the store.
+// Both were read with the same bounds, so once cut down to page.Count they
+// hold what a single timeline with everyone on it would.
+func mergePulled(ids []int64, tweets, pulled []tweet.Tweet, page tweet.Page) ([]int64, []tweet.Tweet) {
+	ids = merge(ids, tweet.IDs(pulled), func(id int64) int64 { return id })
+	if len(ids) > page.Count {
+		if page.Forward() {
+			ids = ids[len(ids)-page.Count:]
+		} else {
+			ids = ids[:page.Count]
+		}
+	}
+	if len(ids) == 0 {
+		return ids, []tweet.Tweet{}
+	}
+
+	newest, oldest := ids[0], ids[len(ids)-1]
+	tweets = slices.DeleteFunc(merge(tweets, pulled, func(t tweet.Tweet) int64 { return t.ID }), func(t tweet.Tweet) bool {
+		return t.ID > newest || t.ID < oldest
+	})
+	return ids, tweets
+}
+
+// merge merges two lists that are sorted newest first by id, an item in
+// both is kept once.
+func merge[T any](a, b []T, id func(T) int64) []T {
+	out := make([]T, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && id(a[i]) > id(b[j])):
+			out = append(out, a[i])
+			i++
+		case i == len(a) || id(b[j]) > id(a[i]):
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return out
+}
+
+// paginate drops duplicate retweets after the cursors are set, see
+// tweet.NewFeedPage.
+func paginate(ids []int64, tweets []tweet.Tweet, page tweet.Page) *tweet.FeedPage {
+	out := tweet.NewFeedPage(ids, tweets, page)
+	out.Tweets = dedupe(out.Tweets)
+	return out
+}
+
+// dedupe keeps the newest appearance of every tweet, so an original shows
+// up once no matter how many followed users retweeted it.
+func dedupe(tweets []tweet.Tweet) []tweet.Tweet {
+	seen := map[int64]bool{}
+	out := make([]tweet.Tweet, 0, len(tweets))
+	for _, t := range tweets {
+		id := t.ID
+		if t.Kind == tweet.KindRetweet && t.OriginalID != nil {
+			id = *t.OriginalID
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, t)
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package timeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+// mockTweetService only implements what the timeline calls.
+type mockTweetService struct {
+	tweet.TweetService
+	tweets []tweet.Tweet
+	// userIds and page are what GetFromUsers was last called with
+	userIds []int64
+	page tweet.Page
+}
+
+// GetByIDs skips IDs it does not know, the same as deleted tweets.
+func (s *mockTweetService) GetByIDs(ctx context.Context, tweetIDs []int64) ([]tweet.Tweet, error) {
+	out := []tweet.Tweet{}
+	for _, id := range tweetIDs {
+		for _, t := range s.tweets {
+			if t.ID == id {
+				out = append(out, t)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *mockTweetService) MergePending(ctx context.Context, tweets []tweet.Tweet, page tweet.Page) []tweet.Tweet {
+	return tweets
+}
+
+func (s *mockTweetService) GetFromUsers(ctx context.Context, userIds []int64, page tweet.Page) ([]tweet.Tweet, error) {
+	s.userIds, s.page = userIds, page
+
+	out := []tweet.Tweet{}
+	for _, t := range s.tweets {
+		if slices.Contains(userIds, t.UserID) && page.Contains(t.ID) {
+			out = append(out, t)
+		}
+	}
+	return page.Trim(out), nil
+}
+
+type mockUserService struct {
+	user.UserService
+	follows map[int64][]int64
+	err error
+}
+
+func (s *mockUserService) GetFollows(ctx context.Context, userId int64) ([]user.Follow, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	follows := []user.Follow{}
+	for _, id := range s.follows[userId] {
+		follows = append(follows, user.Follow{FollowerID: userId, FollowedID: id})
+	}
+	return follows, nil
+}
+
+func ids(tweets []tweet.Tweet) []int64 {
+	out := []int64{}
+	for _, t := range tweets {
+		out = append(out, t.ID)
+	}
+	return out
+}
+
+func TestServiceGetTweetsAuthors(t *testing.T) {
+	tweets := &mockTweetService{}
+	users := &mockUserService{follows: map[int64][]int64{1: {2, 3}}}
+	srv := NewService(tweets, users)
+
+	tests := []struct{
+		name string
+		userId int64
+		expectedUserIds []int64
+	}{
+		{"includes the viewer and who they follow", 1, []int64{1, 2, 3}},
+		{"includes the viewer who follows nobody", 4, []int64{4}},
+	}
+
+	for _, tt := range tests {
+		if _, err := srv.GetTweets(context.Background(), tt.userId, tweet.Page{Count: 20}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !slices.Equal(tweets.userIds, tt.expectedUserIds) {
+			t.Errorf("%s: expected tweets of %v, got %v", tt.name, tt.expectedUserIds, tweets.userIds)
+		}
+	}
+}
+
+func TestServiceGetTweetsFollowsError(t *testing.T) {
+	srv := NewService(&mockTweetService{}, &mockUserService{err: errors.New("db down")})
+
+	if _, err := srv.GetTweets(context.Background(), 1, tweet.Page{Count: 20}); err == nil {
+		t.Errorf("expected the follows error to be returned")
+	}
+}
+
+func TestServiceGetTweetsPages(t *testing.T) {
+	original := int64(1)
+	tweets := &mockTweetService{
+		tweets: []tweet.Tweet{
+			{ID: 6, UserID: 2, Kind: tweet.KindRetweet, OriginalID: &original},
+			{ID: 5, UserID: 1, Kind: tweet.KindTweet},
+			{ID: 4, UserID: 3, Kind: tweet.KindRetweet, OriginalID: &original},
+			{ID: 3, UserID: 2, Kind: tweet.KindTweet},
+			{ID: 2, UserID: 9, Kind: tweet.KindTweet},
+			{ID: 1, UserID: 3, Kind: tweet.KindTweet},
+		},
+	}
+	users := &mockUserService{follows: map[int64][]int64{1: {2, 3}}}
+	srv := NewService(tweets, users)
+	ctx := context.Background()
+
+	page, err := srv.GetTweets(ctx, 1, tweet.Page{Count: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{6, 5}) {
+		t.Errorf("expected the second retweet to be deduplicated, got %v", got)
+	}
+	// the cursor comes from what was fetched, not from what was left
+	if page.NextCursor != 4 || page.PrevCursor != 6 {
+		t.Errorf("expected cursors 4 and 6, got %d and %d", page.NextCursor, page.PrevCursor)
+	}
+
+	page, err = srv.GetTweets(ctx, 1, tweet.Page{MaxID: page.NextCursor, Count: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{3, 1}) {
+		t.Errorf("expected the rest of the timeline, got %v", got)
+	}
+	if page.NextCursor != 0 {
+		t.Errorf("expected no more pages, got cursor %d", page.NextCursor)
+	}
+
+	page, err = srv.GetTweets(ctx, 1, tweet.Page{SinceID: 6, Count: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Tweets) != 0 || page.PrevCursor != 6 {
+		t.Errorf("expected nothing newer and the same cursor to poll with, got %v and %d", ids(page.Tweets), page.PrevCursor)
+	}
+}
+
+func TestServiceGetTweetsFromStore(t *testing.T) {
+	tweets := &mockTweetService{
+		tweets: []tweet.Tweet{{ID: 4, UserID: 2}, {ID: 2, UserID: 3}, {ID: 1, UserID: 2}},
+	}
+	store := NewMemoryStore()
+	ctx := context.Background()
+	// tweet 3 was deleted after it was fanned out
+	store.Append(ctx, []TimelineEntry{
+		{UserID: 1, TweetID: 4, AuthorID: 2},
+		{UserID: 1, TweetID: 3, AuthorID: 2},
+		{UserID: 1, TweetID: 2, AuthorID: 3},
+		{UserID: 1, TweetID: 1, AuthorID: 2},
+	})
+	srv := NewService(tweets, &mockUserService{}, WithStore(store))
+
+	page, err := srv.GetTweets(ctx, 1, tweet.Page{Count: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{4}) {
+		t.Errorf("expected the deleted tweet to be left out, got %v", got)
+	}
+	if page.NextCursor != 3 {
+		t.Errorf("expected the cursor to continue below the deleted tweet, got %d", page.NextCursor)
+	}
+	if tweets.userIds != nil {
+		t.Errorf("expected the timeline to come from the store only")
+	}
+
+	page, _ = srv.GetTweets(ctx, 1, tweet.Page{MaxID: page.NextCursor, Count: 2})
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{2, 1}) {
+		t.Errorf("expected the rest of the timeline, got %v", got)
+	}
+}
+
+func TestServiceGetTweetsPulled(t *testing.T) {
+	// 3 has more followers than the threshold, so their tweets are pulled,
+	// 9 was fanned out before they got there
+	tweets := &mockTweetService{
+		tweets: []tweet.Tweet{
+			{ID: 13, UserID: 2},
+			{ID: 12, UserID: 3},
+			{ID: 11, UserID: 3},
+			{ID: 10, UserID: 2},
+			{ID: 9, UserID: 3},
+		},
+	}
+	store := NewMemoryStore()
+	ctx := context.Background()
+	store.Append(ctx, []TimelineEntry{
+		{UserID: 1, TweetID: 13, AuthorID: 2},
+		{UserID: 1, TweetID: 10, AuthorID: 2},
+		{UserID: 1, TweetID: 9, AuthorID: 3},
+	})
+	users := &mockUserService{follows: map[int64][]int64{1: {2, 3}}}
+	pull := NewPullPolicy(2, mockFollowers{2: {1}, 3: {1, 4, 5}}, store)
+	srv := NewService(tweets, users, WithStore(store), WithPull(pull))
+
+	page, err := srv.GetTweets(ctx, 1, tweet.Page{Count: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{13, 12, 11}) {
+		t.Errorf("expected pulled tweets merged in order, got %v", got)
+	}
+	if page.NextCursor != 11 {
+		t.Errorf("expected the cursor at the last tweet of the page, got %d", page.NextCursor)
+	}
+
+	page, err = srv.GetTweets(ctx, 1, tweet.Page{MaxID: page.NextCursor, Count: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Tweets); !slices.Equal(got, []int64{10, 9}) {
+		t.Errorf("expected the tweet in both the store and the pull once, got %v", got)
+	}
+}
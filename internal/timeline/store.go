@@ -0,0 +1,233 @@
+package timeline
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/tweet"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TimelineEntry puts a tweet on the home timeline of UserID. AuthorID is
+// who posted or retweeted it, so unfollowing can take it off again.
+type TimelineEntry struct {
+	UserID int64 `gorm:"primaryKey;index:idx_timeline_author,priority:1"`
+	TweetID int64 `gorm:"primaryKey;autoIncrement:false"`
+	AuthorID int64 `gorm:"index:idx_timeline_author,priority:2"`
+}
+
+// PulledAuthor records that the tweets of UserID are pulled into timelines
+// rather than fanned out, see PullPolicy.
+type PulledAuthor struct {
+	UserID int64 `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt time.Time
+}
+
+// TimelineStore keeps materialized home timelines, so reading one is a
+// range scan over a single user's entries.
+type TimelineStore interface {
+	// Append puts entries on their timelines, entries already there are
+	// skipped.
+	Append(ctx context.Context, entries []TimelineEntry) error
+	// Range returns the IDs of the tweets on the timeline of userId that
+	// fall on page, newest first.
+	Range(ctx context.Context, userId int64, page tweet.Page) ([]int64, error)
+	// Purge takes everything by authorId off the timeline of userId.
+	Purge(ctx context.Context, userId, authorId int64) error
+
+	// the authors a PullPolicy pulls are kept next to the timelines they
+	// are left out of
+	PulledStore
+}
+
+// memoryStore is a TimelineStore for development and tests. Every timeline
+// is kept sorted newest first.
+type memoryStore struct {
+	mu sync.RWMutex
+	timelines map[int64][]TimelineEntry
+	pulled map[int64]bool
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{timelines: map[int64][]TimelineEntry{}, pulled: map[int64]bool{}}
+}
+
+func (s *memoryStore) Append(ctx context.Context, entries []TimelineEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range entries {
+		timeline := s.timelines[e.UserID]
+		i, found := s.search(timeline, e.TweetID)
+		if found {
+			continue
+		}
+		s.timelines[e.UserID] = slices.Insert(timeline, i, e)
+	}
+	return nil
+}
+
+func (s *memoryStore) Range(ctx context.Context, userId int64, page tweet.Page) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	timeline := s.timelines[userId]
+	from, to := 0, len(timeline)
+	if page.MaxID > 0 {
+		from, _ = s.search(timeline, page.MaxID-1)
+	}
+	if page.SinceID > 0 {
+		to, _ = s.search(timeline, page.SinceID)
+	}
+	if from > to {
+		from = to
+	}
+	if to-from > page.Count {
+		if page.Forward() {
+			from = to - page.Count
+		} else {
+			to = from + page.Count
+		}
+	}
+
+	ids := make([]int64, 0, to-from)
+	for _, e := range timeline[from:to] {
+		ids = append(ids, e.TweetID)
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, userId, authorId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timelines[userId] = slices.DeleteFunc(s.timelines[userId], func(e TimelineEntry) bool {
+		return e.AuthorID == authorId
+	})
+	return nil
+}
+
+func (s *memoryStore) GetPulled(ctx context.Context, userIds []int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pulled := []int64{}
+	for _, id := range userIds {
+		if s.pulled[id] {
+			pulled = append(pulled, id)
+		}
+	}
+	return pulled, nil
+}
+
+func (s *memoryStore) AddPulled(ctx context.Context, userIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range userIds {
+		s.pulled[id] = true
+	}
+	return nil
+}
+
+// search finds where tweetID is or would go in a timeline sorted newest
+// first.
+func (s *memoryStore) search(timeline []TimelineEntry, tweetID int64) (int, bool) {
+	return slices.BinarySearchFunc(timeline, tweetID, func(e TimelineEntry, id int64) int {
+		return cmp.Compare(id, e.TweetID)
+	})
+}
+
+// postgresStore is a TimelineStore on the timeline_entries table, the
+// primary key doubles as the index the range scans run on.
+type postgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *postgresStore {
+	return &postgresStore{db: db}
+}
+
+func (s *postgresStore) Append(ctx context.Context, entries []TimelineEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	err := gorm.G[TimelineEntry](s.db, clause.OnConflict{DoNothing: true}).CreateInBatches(ctx, &entries, 1000)
+	if err != nil {
+		log.Printf("could not append %d timeline entries: %v", len(entries), err)
+		return err
+	}
+	return nil
+}
+
+func (s *postgresStore) Range(ctx context.Context, userId int64, page tweet.Page) ([]int64, error) {
+	q := gorm.G[TimelineEntry](s.db).Where("user_id = ?", userId)
+	if page.MaxID > 0 {
+		q = q.Where("tweet_id < ?", page.MaxID)
+	}
+	if page.SinceID > 0 {
+		q = q.Where("tweet_id > ?", page.SinceID)
+	}
+
+	order := "tweet_id DESC"
+	if page.Forward() {
+		order = "tweet_id ASC"
+	}
+
+	entries, err := q.Order(order).Limit(page.Count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch timeline of userId=%d: %v", userId, err)
+		return nil, err
+	}
+
+	if page.Forward() {
+		slices.Reverse(entries)
+	}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.TweetID
+	}
+	return ids, nil
+}
+
+func (s *postgresStore) Purge(ctx context.Context, userId, authorId int64) error {
+	_, err := gorm.G[TimelineEntry](s.db).Where("user_id = ? AND author_id = ?", userId, authorId).Delete(ctx)
+	if err != nil {
+		log.Printf("could not purge tweets of userId=%d from the timeline of userId=%d: %v", authorId, userId, err)
+		return err
+	}
+	return nil
+}
+
+func (s *postgresStore) GetPulled(ctx context.Context, userIds []int64) ([]int64, error) {
+	authors, err := gorm.G[PulledAuthor](s.db).Where("user_id IN ?", userIds).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch pulled authors: %v", err)
+		return nil, err
+	}
+
+	pulled := make([]int64, len(authors))
+	for i, a := range authors {
+		pulled[i] = a.UserID
+	}
+	return pulled, nil
+}
+
+func (s *postgresStore) AddPulled(ctx context.Context, userIds []int64) error {
+	authors := make([]PulledAuthor, len(userIds))
+	for i, id := range userIds {
+		authors[i] = PulledAuthor{UserID: id}
+	}
+
+	if err := gorm.G[PulledAuthor](s.db, clause.OnConflict{DoNothing: true}).CreateInBatches(ctx, &authors, len(authors)); err != nil {
+		log.Printf("could not record %d pulled authors: %v", len(authors), err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/tweet"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	entries := []TimelineEntry{}
+	for _, id := range []int64{3, 1, 5, 2, 4, 3} {
+		entries = append(entries, TimelineEntry{UserID: 1, TweetID: id, AuthorID: id % 2})
+	}
+	entries = append(entries, TimelineEntry{UserID: 2, TweetID: 9, AuthorID: 1})
+	if err := store.Append(ctx, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct{
+		name string
+		page tweet.Page
+		expected []int64
+	}{
+		{"newest first without duplicates", tweet.Page{Count: 10}, []int64{5, 4, 3, 2, 1}},
+		{"first page", tweet.Page{Count: 2}, []int64{5, 4}},
+		{"below max_id", tweet.Page{MaxID: 4, Count: 2}, []int64{3, 2}},
+		{"right above since_id", tweet.Page{SinceID: 1, Count: 2}, []int64{3, 2}},
+		{"between both", tweet.Page{MaxID: 5, SinceID: 2, Count: 10}, []int64{4, 3}},
+		{"past the end", tweet.Page{MaxID: 1, Count: 10}, []int64{}},
+	}
+
+	for _, tt := range tests {
+		ids, err := store.Range(ctx, 1, tt.page)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !slices.Equal(ids, tt.expected) {
+			t.Errorf("%s: expected %v got %v", tt.name, tt.expected, ids)
+		}
+	}
+
+	if err := store.Purge(ctx, 1, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids, _ := store.Range(ctx, 1, tweet.Page{Count: 10})
+	if !slices.Equal(ids, []int64{4, 2}) {
+		t.Errorf("expected the tweets of author 1 to be purged, got %v", ids)
+	}
+	ids, _ = store.Range(ctx, 2, tweet.Page{Count: 10})
+	if !slices.Equal(ids, []int64{9}) {
+		t.Errorf("expected other timelines to be left alone, got %v", ids)
+	}
+}
@@ -0,0 +1,39 @@
+package tweet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/batch"
+)
+
+// BatchConfig controls how TweetHandler groups queued tweets into inserts.
+type BatchConfig struct {
+	batch.Config
+	// SyncWait bounds how long PostTweet waits for the flush before
+	// answering 202 and leaving the tweet in the queue, 0 answers right away.
+	SyncWait time.Duration
+}
+
+func DefaultBatchConfig() BatchConfig {
+	cfg := batch.DefaultConfig()
+	cfg.MaxBatchSize = 300
+	cfg.MaxWait = 50 * time.Millisecond
+	cfg.QueueSize = 1000
+	cfg.MinBatchSize = 50
+	return BatchConfig{Config: cfg, SyncWait: 5 * time.Second}
+}
+
+func (c BatchConfig) Validate() error {
+	if c.SyncWait < 0 {
+		return errors.New("sync wait cannot be negative")
+	}
+	return c.Config.Validate()
+}
+
+// WithBatching replaces DefaultBatchConfig, cfg has to be valid.
+func WithBatching(cfg BatchConfig) HandlerOption {
+	return func(h *TweetHandler) {
+		h.batching = cfg
+	}
+}
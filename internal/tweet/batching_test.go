@@ -0,0 +1,20 @@
+package tweet
+
+import "testing"
+
+func TestDefaultBatchConfig(t *testing.T) {
+	cfg := DefaultBatchConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the default config to be valid: %v", err)
+	}
+
+	cfg.Adaptive = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the default config to be valid in adaptive mode: %v", err)
+	}
+
+	cfg.SyncWait = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected a negative sync wait to be rejected")
+	}
+}
@@ -0,0 +1,221 @@
+package tweet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a queued tweet that could not be stored even on its own
+// after the batch it was part of kept failing.
+type DeadLetter struct {
+	ID int64 `json:"id" gorm:"primaryKey"`
+	Tweet Tweet `json:"tweet" gorm:"serializer:json"`
+	Error string `json:"error"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type DeadLetterRepo interface {
+	InsertDeadLetter(ctx context.Context, dl *DeadLetter) error
+	GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error)
+	GetDeadLetters(ctx context.Context, afterID int64, count int) ([]DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id int64) error
+}
+
+type deadLetterRepo struct {
+	db *gorm.DB
+}
+
+func NewDeadLetterRepo(db *gorm.DB) *deadLetterRepo {
+	return &deadLetterRepo{db: db}
+}
+
+func (r *deadLetterRepo) InsertDeadLetter(ctx context.Context, dl *DeadLetter) error {
+	if err := gorm.G[DeadLetter](r.db).Create(ctx, dl); err != nil {
+		log.Printf("could not dead-letter tweet for userId=%d: %v", dl.Tweet.UserID, err)
+		return err
+	}
+	return nil
+}
+
+func (r *deadLetterRepo) GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error) {
+	dl, err := gorm.G[DeadLetter](r.db).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+func (r *deadLetterRepo) GetDeadLetters(ctx context.Context, afterID int64, count int) ([]DeadLetter, error) {
+	dls, err := gorm.G[DeadLetter](r.db).Where("id > ?", afterID).Order("id ASC").Limit(count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch dead letters: %v", err)
+		return nil, err
+	}
+	return dls, nil
+}
+
+func (r *deadLetterRepo) DeleteDeadLetter(ctx context.Context, id int64) error {
+	n, err := gorm.G[DeadLetter](r.db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		log.Printf("could not delete dead letter %d: %v", id, err)
+		return err
+	}
+	if n == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+type DeadLetterService interface {
+	// Add records a tweet that failed to store together with the cause.
+	Add(ctx context.Context, tweet Tweet, cause error) error
+	List(ctx context.Context, afterID int64, count int) ([]DeadLetter, error)
+	// Replay posts a dead-lettered tweet again and removes it on success.
+	Replay(ctx context.Context, id int64) (*Tweet, error)
+	Discard(ctx context.Context, id int64) error
+}
+
+type deadLetterService struct {
+	repo DeadLetterRepo
+	tweets TweetService
+}
+
+func NewDeadLetterService(repo DeadLetterRepo, tweets TweetService) *deadLetterService {
+	return &deadLetterService{repo: repo, tweets: tweets}
+}
+
+func (s *deadLetterService) Add(ctx context.Context, tweet Tweet, cause error) error {
+	// the conversation is assigned while storing, a failed insert may
+	// have left one behind
+	tweet.ConversationID = 0
+
+	return s.repo.InsertDeadLetter(ctx, &DeadLetter{
+		Tweet: tweet,
+		Error: cause.Error(),
+	})
+}
+
+func (s *deadLetterService) List(ctx context.Context, afterID int64, count int) ([]DeadLetter, error) {
+	return s.repo.GetDeadLetters(ctx, afterID, count)
+}
+
+func (s *deadLetterService) Replay(ctx context.Context, id int64) (*Tweet, error) {
+	dl, err := s.repo.GetDeadLetter(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the tweet keeps its ID, so a replay that went through before, but
+	// failed to remove the dead letter, only has to remove it now
+	stored, err := s.tweets.Stored(ctx, []int64{dl.Tweet.ID})
+	if err != nil {
+		return nil, err
+	}
+	tweets := []Tweet{dl.Tweet}
+	if !stored[dl.Tweet.ID] {
+		if err := s.tweets.Post(ctx, tweets); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.DeleteDeadLetter(ctx, id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &tweets[0], nil
+}
+
+func (s *deadLetterService) Discard(ctx context.Context, id int64) error {
+	err := s.repo.DeleteDeadLetter(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+type DeadLetterHandler struct {
+	svc DeadLetterService
+}
+
+func NewDeadLetterHandler(svc DeadLetterService) *DeadLetterHandler {
+	return &DeadLetterHandler{svc: svc}
+}
+
+func (h *DeadLetterHandler) List(w http.ResponseWriter, r *http.Request) {
+	_, count, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var afterID int64
+	if v := r.URL.Query().Get("after_id"); v != "" {
+		if afterID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid after_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	dls, err := h.svc.List(r.Context(), afterID, count)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dls)
+}
+
+func (h *DeadLetterHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tweet, err := h.svc.Replay(r.Context(), id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("could not replay dead letter %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tweet)
+}
+
+func (h *DeadLetterHandler) Discard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.svc.Discard(r.Context(), id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package tweet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type mockDeadLetterRepo struct {
+	dls map[int64]DeadLetter
+}
+
+func (r *mockDeadLetterRepo) InsertDeadLetter(ctx context.Context, dl *DeadLetter) error {
+	dl.ID = int64(len(r.dls)) + 1
+	r.dls[dl.ID] = *dl
+	return nil
+}
+
+func (r *mockDeadLetterRepo) GetDeadLetter(ctx context.Context, id int64) (*DeadLetter, error) {
+	dl, ok := r.dls[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &dl, nil
+}
+
+func (r *mockDeadLetterRepo) GetDeadLetters(ctx context.Context, afterID int64, count int) ([]DeadLetter, error) {
+	return nil, nil
+}
+
+func (r *mockDeadLetterRepo) DeleteDeadLetter(ctx context.Context, id int64) error {
+	if _, ok := r.dls[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.dls, id)
+	return nil
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	tweets := NewMockTweetService()
+	// posting a tweet whose ID is taken fails like the primary key would
+	tweets.failPost = func(batch []Tweet) error {
+		for _, t := range batch {
+			if _, ok := tweets.tweets[t.ID]; ok {
+				return gorm.ErrDuplicatedKey
+			}
+		}
+		return nil
+	}
+	repo := &mockDeadLetterRepo{dls: map[int64]DeadLetter{}}
+	srv := NewDeadLetterService(repo, tweets)
+	ctx := context.Background()
+
+	srv.Add(ctx, Tweet{ID: 10, UserID: 7, Text: "lost"}, errors.New("invalid byte sequence"))
+	srv.Add(ctx, Tweet{ID: 20, UserID: 7, Text: "stored by an earlier replay"}, errors.New("invalid byte sequence"))
+	tweets.tweets[20] = &Tweet{ID: 20, UserID: 7, Text: "stored by an earlier replay"}
+
+	for id, tweetID := range map[int64]int64{1: 10, 2: 20} {
+		tweet, err := srv.Replay(ctx, id)
+		if err != nil {
+			t.Fatalf("dead letter %d: unexpected error: %v", id, err)
+		}
+		if tweet.ID != tweetID {
+			t.Errorf("expected tweet %d back, got %+v", tweetID, tweet)
+		}
+		if _, ok := repo.dls[id]; ok {
+			t.Errorf("expected dead letter %d to be removed", id)
+		}
+	}
+	if len(tweets.tweets) != 2 {
+		t.Errorf("expected one tweet to be posted, got %d stored", len(tweets.tweets))
+	}
+	if _, err := srv.Replay(ctx, 1); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("expected ErrDeadLetterNotFound once replayed, got %v", err)
+	}
+}
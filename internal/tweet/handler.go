@@ -3,34 +3,173 @@ package tweet
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/batch"
+	"github.com/daniiltsioma/twitter/internal/wal"
 	"github.com/go-chi/chi"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize = 100
+
+	maxRetryBackoff = 5 * time.Second
+)
+
+// pendingTweet is a queued tweet.
+type pendingTweet struct {
+	tweet Tweet
+	// seq is the spool entry of the tweet, 0 without a spool
+	seq uint64
+}
+
 type TweetHandler struct {
 	svc TweetService
-	tweetCh chan Tweet
-	maxBatchSize int
-	maxWait time.Duration
+	batcher *batch.Batcher[pendingTweet]
+	batching BatchConfig
+	// spool keeps queued tweets on disk until their batch is flushed
+	spool *wal.Log
+	// a batch that fails for reasons other than its tweets is retried,
+	// starting retryBackoff apart, until it goes through
+	retryBackoff time.Duration
+	deadLetters DeadLetterService
+}
+
+type HandlerOption func(*TweetHandler)
+
+// WithSpool writes every queued tweet to spool before it is accepted, so
+// tweets still in the queue survive a crash. Call Replay on startup to
+// post what the previous process did not get to.
+func WithSpool(spool *wal.Log) HandlerOption {
+	return func(h *TweetHandler) {
+		h.spool = spool
+	}
+}
+
+// WithDeadLetters sets where tweets go that cannot be stored after
+// retrying. Without it they are dropped and the error is logged.
+func WithDeadLetters(deadLetters DeadLetterService) HandlerOption {
+	return func(h *TweetHandler) {
+		h.deadLetters = deadLetters
+	}
 }
 
-func NewHandler(ctx context.Context, svc TweetService) *TweetHandler {
+func NewHandler(ctx context.Context, svc TweetService, opts ...HandlerOption) *TweetHandler {
 	h := &TweetHandler{
 		svc: svc,
-		tweetCh: make(chan Tweet, 200),
-		maxBatchSize: 300,
-		maxWait: 50 * time.Millisecond,
+		batching: DefaultBatchConfig(),
+		retryBackoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
 
-	go h.worker(ctx)
+	// all tweets of an author go through the same worker, so they are
+	// stored in the order they were posted
+	h.batcher = batch.New(ctx, h.batching.Config, h.flush,
+		batch.WithShard(func(p pendingTweet) uint64 { return uint64(p.tweet.UserID) }),
+	)
 	return h
 }
 
+// spoolTweet durably records p before it is queued. The tweet already has
+// its ID, which is what tells on replay whether it was stored.
+func (h *TweetHandler) spoolTweet(p *pendingTweet) error {
+	payload, err := json.Marshal(p.tweet)
+	if err != nil {
+		return err
+	}
+
+	p.seq, err = h.spool.Append(payload)
+	return err
+}
+
+// checkpoint marks spooled tweets as done so they are not replayed.
+func (h *TweetHandler) checkpoint(batch []pendingTweet) {
+	if h.spool == nil {
+		return
+	}
+
+	seqs := make([]uint64, 0, len(batch))
+	for _, p := range batch {
+		if p.seq != 0 {
+			seqs = append(seqs, p.seq)
+		}
+	}
+	if err := h.spool.Ack(seqs...); err != nil {
+		log.Printf("could not checkpoint %d spooled tweets: %v", len(seqs), err)
+	}
+}
+
+// Replay posts the tweets left in the spool by a previous process and
+// returns how many were stored. Tweets that were already stored before it
+// went down are skipped, so a crash between storing a batch and
+// checkpointing it does not duplicate them. Their listeners still run, the
+// outbox they were stored with is relayed by the new process.
+func (h *TweetHandler) Replay(ctx context.Context) (int, error) {
+	if h.spool == nil {
+		return 0, nil
+	}
+
+	var batch []pendingTweet
+	var ids []int64
+	for _, entry := range h.spool.Pending() {
+		var t Tweet
+		if err := json.Unmarshal(entry.Payload, &t); err != nil || t.ID == 0 {
+			log.Printf("dropping unreadable spool entry %d: %v", entry.Seq, err)
+			h.spool.Ack(entry.Seq)
+			continue
+		}
+
+		batch = append(batch, pendingTweet{tweet: t, seq: entry.Seq})
+		ids = append(ids, t.ID)
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	stored, err := h.svc.Stored(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	var todo, done []pendingTweet
+	for _, p := range batch {
+		if stored[p.tweet.ID] {
+			done = append(done, p)
+		} else {
+			todo = append(todo, p)
+		}
+	}
+	h.checkpoint(done)
+
+	replayed := 0
+	for len(todo) > 0 {
+		n := min(len(todo), h.batching.MaxBatchSize)
+		replayed += n
+		for _, err := range h.store(ctx, todo[:n]) {
+			if err != nil {
+				replayed--
+			}
+		}
+		todo = todo[n:]
+	}
+
+	return replayed, nil
+}
+
 func (h *TweetHandler) PostTweet(w http.ResponseWriter, r *http.Request) {
 	userId, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
@@ -38,26 +177,184 @@ func (h *TweetHandler) PostTweet(w http.ResponseWriter, r *http.Request) {
 		return 
 	}
 
-	var in Tweet
+	var in struct {
+		Text string `json:"text"`
+		InReplyToID *int64 `json:"inReplyToId,string"`
+		OriginalID *int64 `json:"originalId,string"`
+		MediaIDs []int64 `json:"mediaIds"`
+	}
 
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, "invalid JSON: " + err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if in.Text == "" {
-		http.Error(w, "tweet.Text cannot be empty", http.StatusBadRequest)
+	// a tweet needs text unless it carries media
+	if err := ValidateText(in.Text); err != nil && !(errors.Is(err, ErrEmptyText) && len(in.MediaIDs) > 0) {
+		writeValidationError(w, err)
 		return
 	}
 
-	in.UserID = userId
+	t := Tweet{
+		UserID: userId,
+		Text: in.Text,
+		Kind: KindTweet,
+		InReplyToID: in.InReplyToID,
+	}
 
-	select {
-	case h.tweetCh <- in:
-		w.WriteHeader(http.StatusAccepted)
-	default:
+	if t.InReplyToID != nil {
+		if _, err := h.svc.Get(r.Context(), *t.InReplyToID); err != nil {
+			http.Error(w, "inReplyToId does not reference an existing tweet", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// retweets go through their own endpoint, anything pointing at
+	// another tweet here is a quote
+	if in.OriginalID != nil {
+		original, err := h.svc.Get(r.Context(), *in.OriginalID)
+		if err != nil {
+			http.Error(w, "originalId does not reference an existing tweet", http.StatusBadRequest)
+			return
+		}
+		t.OriginalID = &original.ID
+		if original.Kind == KindRetweet {
+			t.OriginalID = original.OriginalID
+		}
+		t.Kind = KindQuote
+	}
+
+	mediaIDs, err := h.svc.CheckMedia(r.Context(), userId, in.MediaIDs)
+	switch {
+	case errors.Is(err, ErrTooManyMedia), errors.Is(err, ErrMediaNotOwned):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	t.MediaIDs = mediaIDs
+
+	t.Mentions = h.svc.ResolveMentions(r.Context(), t.Text)
+
+	if err := h.svc.AssignID(&t); err != nil {
+		log.Printf("could not assign tweet ID: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	p := pendingTweet{tweet: t}
+	if h.spool != nil {
+		if err := h.spoolTweet(&p); err != nil {
+			log.Printf("could not spool tweet: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// the author sees the tweet from the moment it is queued, added first
+	// so a quick flush cannot remove it before it is there
+	h.svc.AddPending(p.tweet)
+
+	done, err := h.batcher.Add(p)
+	if err != nil {
+		// the client is told the tweet was not accepted, so it must not
+		// come back on replay
+		h.svc.RemovePending([]Tweet{p.tweet})
+		h.checkpoint([]pendingTweet{p})
+		w.Header().Set("Retry-After", h.retryAfter(p))
 		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	timer := time.NewTimer(h.batching.SyncWait)
+	defer timer.Stop()
+
+	accepted := func() {
+		p.tweet.Pending = true
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(p.tweet)
+	}
+
+	select {
+	case res := <-done:
+		switch {
+		case res.Err == nil:
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(res.Item.tweet)
+		case errors.Is(res.Err, errSpooled):
+			// retrying was cut short by shutting down, the tweet is
+			// stored on replay
+			accepted()
+		default:
+			http.Error(w, "could not store tweet", http.StatusInternalServerError)
+		}
+	case <-timer.C:
+		// still queued, it will be stored with a later flush under the ID
+		// it already has
+		accepted()
+	case <-r.Context().Done():
+	}
+}
+
+// PostThread stores a thread right away instead of queueing it, so that it
+// is stored whole or not at all.
+func (h *TweetHandler) PostThread(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var in struct {
+		Texts []string `json:"texts"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tweets, err := h.svc.PostThread(r.Context(), userId, in.Texts)
+	var textErr *ThreadTextError
+	switch {
+	case errors.As(err, &textErr):
+		writeValidationError(w, err)
+		return
+	case errors.Is(err, ErrEmptyThread), errors.Is(err, ErrThreadTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "could not store thread", http.StatusInternalServerError)
+		return
 	}
+
+	ids := make([]string, len(tweets))
+	for i, t := range tweets {
+		ids[i] = strconv.FormatInt(t.ID, 10)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ids": ids})
+}
+
+// retryAfter is the Retry-After value when the queue of p is full, about
+// the time its worker takes to work through it. The queue only fills up
+// when flushes are slow, so that goes by how long they have been taking.
+func (h *TweetHandler) retryAfter(p pendingTweet) string {
+	wait := h.batcher.Backlog(p)
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// Close stops accepting tweets and waits until the workers have stored
+// everything still queued. It returns how many tweets were drained.
+func (h *TweetHandler) Close(ctx context.Context) (int, error) {
+	return h.batcher.Close(ctx)
+}
+
+// Wait blocks until the workers have stopped, see batch.Batcher.Wait.
+func (h *TweetHandler) Wait() {
+	h.batcher.Wait()
 }
 
 func (h *TweetHandler) GetTweet(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +365,10 @@ func (h *TweetHandler) GetTweet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tweet, err := h.svc.Get(r.Context(), int64(tweetID))
+	if errors.Is(err, ErrTweetDeleted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
 	if err != nil {
 		http.Error(w, "tweet not found", http.StatusNotFound)
 		return
@@ -77,36 +378,466 @@ func (h *TweetHandler) GetTweet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tweet)
 }
 
-func (h *TweetHandler) worker(ctx context.Context) {
-	ticker := time.NewTicker(h.maxWait)
-	defer ticker.Stop()
+func (h *TweetHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := h.svc.GetThread(r.Context(), int64(tweetID))
+	if err != nil {
+		http.Error(w, "tweet not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(thread)
+}
+
+func (h *TweetHandler) GetHashtag(w http.ResponseWriter, r *http.Request) {
+	tag, err := url.PathUnescape(chi.URLParam(r, "tag"))
+	if err != nil || NormalizeHashtag(tag) == "" {
+		http.Error(w, "invalid hashtag", http.StatusBadRequest)
+		return
+	}
+
+	maxID, count, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tweets, err := h.svc.GetByHashtag(r.Context(), tag, maxID, count)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	out := map[string]interface{}{"tweets": tweets}
+	if len(tweets) == count {
+		out["nextMaxId"] = strconv.FormatInt(tweets[len(tweets)-1].ID, 10)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
 
-	batch := make([]Tweet, 0, h.maxBatchSize)
+// GetUserTweets serves the profile of the user in the URL, either by ID or
+// by username.
+func (h *TweetHandler) GetUserTweets(w http.ResponseWriter, r *http.Request) {
+	filter, page, err := profileParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	flush := func() {
-		if len(batch) == 0 {
+	var tweets []Tweet
+	if username := chi.URLParam(r, "username"); username != "" {
+		tweets, err = h.svc.GetByUsername(r.Context(), username, filter, page)
+	} else {
+		userId, convErr := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+		if convErr != nil {
+			http.Error(w, "invalid user id, must be integer", http.StatusBadRequest)
 			return
 		}
-		_ = h.svc.Post(ctx, batch)
-		batch = batch[:0]
+		tweets, err = h.svc.GetByUser(r.Context(), userId, filter, page)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NewFeedPage(IDs(tweets), tweets, page))
+}
+
+// profileParams reads the page of a profile along with the
+// include_replies and include_retweets toggles, replies are left out and
+// retweets shown unless asked otherwise.
+func profileParams(r *http.Request) (ProfileFilter, Page, error) {
+	filter := ProfileFilter{Retweets: true}
+
+	var err error
+	if v := r.URL.Query().Get("include_replies"); v != "" {
+		if filter.Replies, err = strconv.ParseBool(v); err != nil {
+			return ProfileFilter{}, Page{}, errors.New("include_replies must be true or false")
+		}
+	}
+	if v := r.URL.Query().Get("include_retweets"); v != "" {
+		if filter.Retweets, err = strconv.ParseBool(v); err != nil {
+			return ProfileFilter{}, Page{}, errors.New("include_retweets must be true or false")
+		}
+	}
+
+	page, err := PageFromRequest(r)
+	return filter, page, err
+}
+
+// writeValidationError responds to text rejected by ValidateText. Texts
+// that are too long get a 422 with the computed length.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var tooLong *TextTooLongError
+	if !errors.As(err, &tooLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := map[string]interface{}{
+		"error": ErrTextTooLong.Error(),
+		"length": tooLong.Length,
+		"maxLength": MaxTweetLength,
+	}
+	// point at the offending tweet of a thread
+	var textErr *ThreadTextError
+	if errors.As(err, &textErr) {
+		out["index"] = textErr.Index
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(out)
+}
+
+// pageParams reads the max_id and count query parameters of a feed.
+func pageParams(r *http.Request) (maxID int64, count int, err error) {
+	p, err := PageFromRequest(r)
+	return p.MaxID, p.Count, err
+}
+
+func (h *TweetHandler) GetMentions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	maxID, count, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tweets, err := h.svc.GetMentioning(r.Context(), userId, maxID, count)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	out := map[string]interface{}{"tweets": tweets}
+	if len(tweets) == count {
+		out["nextMaxId"] = strconv.FormatInt(tweets[len(tweets)-1].ID, 10)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+func (h *TweetHandler) Retweet(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	retweet, err := h.svc.Retweet(r.Context(), userId, int64(tweetID))
+	if errors.Is(err, ErrTweetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrTweetDeleted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(retweet)
+}
+
+func (h *TweetHandler) Unretweet(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.svc.Unretweet(r.Context(), userId, int64(tweetID))
+	if errors.Is(err, ErrNotRetweeted) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"unretweet": "success"})
+}
+
+func (h *TweetHandler) DeleteTweet(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err = h.svc.Delete(r.Context(), userId, int64(tweetID))
+	switch {
+	case errors.Is(err, ErrTweetNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrTweetDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, ErrNotAuthor):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"delete": "success"})
+}
+
+func (h *TweetHandler) EditTweet(w http.ResponseWriter, r *http.Request) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var in struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid JSON: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tweet, err := h.svc.Edit(r.Context(), userId, int64(tweetID), in.Text)
+	switch {
+	case errors.Is(err, ErrEmptyText), errors.Is(err, ErrTextTooLong):
+		writeValidationError(w, err)
+		return
+	case errors.Is(err, ErrTweetNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrTweetDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, ErrNotAuthor), errors.Is(err, ErrNotEditable), errors.Is(err, ErrEditWindowClosed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrEditConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tweet)
+}
+
+func (h *TweetHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	tweetID, err := strconv.Atoi(chi.URLParam(r, "tweetID"))
+	if err != nil {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := h.svc.History(r.Context(), int64(tweetID))
+	if errors.Is(err, ErrTweetDeleted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "tweet not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// errSpooled is the error of a tweet that could not be stored before
+// shutting down and is left in the spool to be replayed on the next start.
+var errSpooled = errors.New("left in the spool for replay")
+
+// flush stores a batch for the batcher. Tweets that were stored or
+// dead-lettered stop being shown to their authors as pending afterwards,
+// the ones left in the spool stay pending until the replay stores them.
+func (h *TweetHandler) flush(ctx context.Context, batch []pendingTweet) []error {
+	errs := h.store(ctx, batch)
+
+	tweets := make([]Tweet, 0, len(batch))
+	for i, p := range batch {
+		if errs != nil && errors.Is(errs[i], errSpooled) {
+			continue
+		}
+		tweets = append(tweets, p.tweet)
+	}
+	h.svc.RemovePending(tweets)
+	return errs
+}
+
+// store posts a batch and returns the error of each tweet, or nil if all
+// of them were stored. A batch that one of its tweets cannot be stored
+// with is split in halves until the tweets that cannot be stored are
+// isolated and dead-lettered, so one bad tweet does not take the rest of
+// its batch down with it.
+func (h *TweetHandler) store(ctx context.Context, batch []pendingTweet) []error {
+	tweets, err := h.post(ctx, batch)
+	if err == nil {
+		h.checkpoint(batch)
+		for i := range batch {
+			batch[i].tweet = tweets[i]
+		}
+		return nil
+	}
+
+	errs := make([]error, len(batch))
+
+	// shutting down, whatever is spooled is replayed on the next start
+	if ctx.Err() != nil {
+		if h.spool != nil {
+			err = fmt.Errorf("%w: %w", errSpooled, err)
+		}
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
 	}
 
+	if len(batch) > 1 {
+		log.Printf("could not store batch of %d tweets, splitting it: %v", len(batch), err)
+		mid := len(batch) / 2
+		if left := h.store(ctx, batch[:mid]); left != nil {
+			copy(errs, left)
+		}
+		if right := h.store(ctx, batch[mid:]); right != nil {
+			copy(errs[mid:], right)
+		}
+		return errs
+	}
+
+	h.deadLetter(ctx, batch[0], err)
+	errs[0] = err
+	return errs
+}
+
+// post stores the batch. Errors that are down to a tweet of the batch are
+// returned right away, anything else, such as the database being
+// unreachable, is retried with exponential backoff until the batch goes
+// through or ctx is done, which holds up the worker's queue meanwhile.
+func (h *TweetHandler) post(ctx context.Context, batch []pendingTweet) ([]Tweet, error) {
+	backoff := h.retryBackoff
 	for {
-		select {
-		case <-ctx.Done():
-			flush()
+		// a failed insert may have filled in IDs, start over from the queued tweets
+		tweets := make([]Tweet, len(batch))
+		for i, p := range batch {
+			tweets[i] = p.tweet
+		}
+
+		err := h.svc.Post(ctx, tweets)
+		if err == nil {
+			return tweets, nil
+		}
+		if isTweetError(err) {
+			return nil, err
+		}
+
+		log.Printf("could not store batch of %d tweets, retrying in %v: %v", len(batch), backoff, err)
+		if !sleep(ctx, backoff) {
+			return nil, err
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// isTweetError reports whether err is caused by the data of a tweet, such
+// as a violated constraint or text postgres rejects, rather than by the
+// database. Storing the same tweet again fails the same way.
+func isTweetError(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, gorm.ErrForeignKeyViolated) || errors.Is(err, gorm.ErrCheckConstraintViolated) {
+		return true
+	}
+
+	// class 22 are data exceptions, class 23 integrity constraint violations
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23"))
+}
+
+// sleep waits for d and reports whether ctx is still live afterwards.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (h *TweetHandler) deadLetter(ctx context.Context, p pendingTweet, cause error) {
+	if h.deadLetters == nil {
+		log.Printf("dropping tweet from userId=%d that could not be stored: %v", p.tweet.UserID, cause)
+		h.checkpoint([]pendingTweet{p})
+		return
+	}
+
+	backoff := h.retryBackoff
+	for {
+		err := h.deadLetters.Add(ctx, p.tweet, cause)
+		if err == nil {
+			break
+		}
+		// it stays spooled until it is dead-lettered, if that does not
+		// happen before shutting down it is tried again on replay
+		log.Printf("could not dead-letter tweet from userId=%d, retrying in %v: %v", p.tweet.UserID, backoff, err)
+		if !sleep(ctx, backoff) {
 			return
-		case t := <-h.tweetCh:
-			batch = append(batch, t)
-			if len(batch) == h.maxBatchSize {
-				log.Printf("batch full")
-				flush()
-			}
-		case <-ticker.C:
-			if len(batch) != 0 {
-				log.Printf("ticker, batch: %d", len(batch))
-			}
-			flush()
 		}
+		backoff = min(backoff*2, maxRetryBackoff)
 	}
-}
\ No newline at end of file
+	log.Printf("dead-lettered tweet from userId=%d: %v", p.tweet.UserID, cause)
+	h.checkpoint([]pendingTweet{p})
+}
@@ -1,44 +1,228 @@
 package tweet
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/batch"
+	"github.com/daniiltsioma/twitter/internal/wal"
 	"github.com/go-chi/chi"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
 )
 
 type mockTweetService struct {
 	tweets map[int64]*Tweet
+	postErr error
+	// failPost, if set, decides per call whether Post fails
+	failPost func(tweets []Tweet) error
+	nextID int64
+
+	mu sync.Mutex
+	pending map[int64]Tweet
 }
 
 func NewMockTweetService() *mockTweetService {
 	return &mockTweetService{
 		tweets: map[int64]*Tweet{},
+		pending: map[int64]Tweet{},
 	}
 }
 
 func (s *mockTweetService) Post(ctx context.Context, tweets []Tweet) error {
+	if s.postErr != nil {
+		return s.postErr
+	}
+	if s.failPost != nil {
+		if err := s.failPost(tweets); err != nil {
+			return err
+		}
+	}
+	for i := range tweets {
+		s.AssignID(&tweets[i])
+		s.tweets[tweets[i].ID] = &tweets[i]
+	}
 	return nil
 }
 
+func (s *mockTweetService) PostThread(ctx context.Context, userId int64, texts []string) ([]Tweet, error) {
+	if len(texts) == 0 {
+		return nil, ErrEmptyThread
+	}
+	for i, text := range texts {
+		if err := ValidateText(text); err != nil {
+			return nil, &ThreadTextError{Index: i, Err: err}
+		}
+	}
+
+	tweets := make([]Tweet, len(texts))
+	for i, text := range texts {
+		tweets[i] = Tweet{UserID: userId, Text: text}
+		if i > 0 {
+			tweets[i].InReplyToID = &tweets[i-1].ID
+		}
+	}
+	return tweets, s.Post(ctx, tweets)
+}
+
 func (s *mockTweetService) Get(ctx context.Context, tweetID int64) (*Tweet, error) {
 	tweet, ok := s.tweets[tweetID]
 	if !ok {
 		return nil, errors.New("tweet not found")
 	}
+	if tweet.Deleted {
+		return nil, ErrTweetDeleted
+	}
 	return tweet, nil
 }
 
-func (s *mockTweetService) GetFromUsers(ctx context.Context, usedIds []int64) ([]Tweet, error) {
+func (s *mockTweetService) Exists(ctx context.Context, tweetID int64) error {
+	_, err := s.Get(ctx, tweetID)
+	return err
+}
+
+func (s *mockTweetService) Delete(ctx context.Context, userId, tweetID int64) error {
+	tweet, err := s.Get(ctx, tweetID)
+	if err != nil {
+		return err
+	}
+	tweet.Deleted = true
+	return nil
+}
+
+func (s *mockTweetService) GetFromUsers(ctx context.Context, usedIds []int64, page Page) ([]Tweet, error) {
 	return nil, nil
 }
 
+func (s *mockTweetService) GetByIDs(ctx context.Context, tweetIDs []int64) ([]Tweet, error) {
+	return nil, nil
+}
+
+func (s *mockTweetService) GetByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error) {
+	if userId != 7 {
+		return nil, ErrUserNotFound
+	}
+
+	tweets := []Tweet{}
+	for _, t := range s.tweets {
+		if t.UserID == userId && filter.Allows(*t) && page.Contains(t.ID) {
+			tweets = append(tweets, *t)
+		}
+	}
+	slices.SortFunc(tweets, func(a, b Tweet) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return page.Trim(tweets), nil
+}
+
+func (s *mockTweetService) GetByUsername(ctx context.Context, username string, filter ProfileFilter, page Page) ([]Tweet, error) {
+	if username != "alice" {
+		return nil, ErrUserNotFound
+	}
+	return s.GetByUser(ctx, 7, filter, page)
+}
+
+func (s *mockTweetService) MergePending(ctx context.Context, tweets []Tweet, page Page) []Tweet {
+	return tweets
+}
+
+func (s *mockTweetService) Retweet(ctx context.Context, userId, tweetID int64) (*Tweet, error) {
+	if _, ok := s.tweets[tweetID]; !ok {
+		return nil, ErrTweetNotFound
+	}
+	return &Tweet{UserID: userId, Kind: KindRetweet, OriginalID: &tweetID}, nil
+}
+
+func (s *mockTweetService) Unretweet(ctx context.Context, userId, tweetID int64) error {
+	return nil
+}
+
+func (s *mockTweetService) Edit(ctx context.Context, userId, tweetID int64, text string) (*Tweet, error) {
+	tweet, err := s.Get(ctx, tweetID)
+	if err != nil {
+		return nil, err
+	}
+	tweet.Text = text
+	return tweet, nil
+}
+
+func (s *mockTweetService) History(ctx context.Context, tweetID int64) ([]Revision, error) {
+	return nil, nil
+}
+
+func (s *mockTweetService) GetByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error) {
+	return nil, nil
+}
+
+func (s *mockTweetService) GetMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error) {
+	return nil, nil
+}
+
+func (s *mockTweetService) ResolveMentions(ctx context.Context, text string) []Mention {
+	return nil
+}
+
+func (s *mockTweetService) CheckMedia(ctx context.Context, userId int64, mediaIDs []int64) ([]int64, error) {
+	return mediaIDs, nil
+}
+
+func (s *mockTweetService) AssignID(tweet *Tweet) error {
+	if tweet.ID == 0 {
+		s.nextID++
+		tweet.ID = s.nextID
+	}
+	return nil
+}
+
+func (s *mockTweetService) Stored(ctx context.Context, tweetIDs []int64) (map[int64]bool, error) {
+	stored := map[int64]bool{}
+	for _, id := range tweetIDs {
+		if _, ok := s.tweets[id]; ok {
+			stored[id] = true
+		}
+	}
+	return stored, nil
+}
+
+func (s *mockTweetService) AddPending(tweet Tweet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[tweet.ID] = tweet
+}
+
+func (s *mockTweetService) RemovePending(tweets []Tweet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range tweets {
+		delete(s.pending, t.ID)
+	}
+}
+
+func (s *mockTweetService) GetThread(ctx context.Context, tweetID int64) (*Thread, error) {
+	tweet, err := s.Get(ctx, tweetID)
+	if err != nil {
+		return nil, err
+	}
+	return &Thread{Tweet: *tweet}, nil
+}
+
 func TestHandlerGetTweet(t *testing.T) {
 	svc := NewMockTweetService()
 	svc.tweets[1] = &Tweet{ID: 1, Text: "hello"}
+	svc.tweets[3] = &Tweet{ID: 3, Text: "deleted", Deleted: true}
 
 	handler := NewHandler(context.Background(), svc)
 
@@ -50,6 +234,7 @@ func TestHandlerGetTweet(t *testing.T) {
 		{"GetTweet_ExistingID", "1", http.StatusOK},
 		{"GetTweet_InvalidURL", "hi", http.StatusBadRequest},
 		{"GetTweet_NonExistingID", "2", http.StatusNotFound},
+		{"GetTweet_DeletedID", "3", http.StatusGone},
 	}
 
 	for _, tt := range tests {
@@ -69,4 +254,682 @@ func TestHandlerGetTweet(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestHandlerPostTweetValidation(t *testing.T) {
+	handler := NewHandler(context.Background(), NewMockTweetService())
+
+	tests := []struct{
+		name string
+		body string
+		expectedStatus int
+	}{
+		{"PostTweet_Valid", `{"text": "hello"}`, http.StatusCreated},
+		{"PostTweet_Empty", `{"text": ""}`, http.StatusBadRequest},
+		{"PostTweet_MediaOnly", `{"text": "", "mediaIds": [1]}`, http.StatusCreated},
+		{"PostTweet_TooLong", `{"text": "` + strings.Repeat("字", MaxTweetLength / 2 + 1) + `"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithUserID(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+
+			handler.PostTweet(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "` + strings.Repeat("a", MaxTweetLength + 5) + `"}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 1))
+	rr := httptest.NewRecorder()
+	handler.PostTweet(rr, req)
+
+	var out struct {
+		Length int `json:"length"`
+		MaxLength int `json:"maxLength"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if out.Length != MaxTweetLength + 5 || out.MaxLength != MaxTweetLength {
+		t.Errorf("expected length %d of %d, got %+v", MaxTweetLength + 5, MaxTweetLength, out)
+	}
+}
+
+func TestHandlerPostThread(t *testing.T) {
+	svc := NewMockTweetService()
+	handler := NewHandler(context.Background(), svc)
+
+	tests := []struct{
+		name string
+		body string
+		expectedStatus int
+	}{
+		{"PostThread_Valid", `{"texts": ["1/3", "2/3", "3/3"]}`, http.StatusCreated},
+		{"PostThread_Empty", `{"texts": []}`, http.StatusBadRequest},
+		{"PostThread_EmptyText", `{"texts": ["1/2", ""]}`, http.StatusBadRequest},
+		{"PostThread_TooLong", `{"texts": ["1/2", "` + strings.Repeat("a", MaxTweetLength + 1) + `"]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithUserID(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+
+			handler.PostThread(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"texts": ["first", "second"]}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 1))
+	rr := httptest.NewRecorder()
+	handler.PostThread(rr, req)
+
+	var out struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if len(out.IDs) != 2 {
+		t.Fatalf("expected 2 IDs, got %v", out.IDs)
+	}
+	firstID, _ := strconv.ParseInt(out.IDs[0], 10, 64)
+	secondID, _ := strconv.ParseInt(out.IDs[1], 10, 64)
+	first, second := svc.tweets[firstID], svc.tweets[secondID]
+	if first.Text != "first" || second.Text != "second" {
+		t.Errorf("expected the IDs in thread order, got %q then %q", first.Text, second.Text)
+	}
+	if second.InReplyToID == nil || *second.InReplyToID != first.ID {
+		t.Errorf("expected the second tweet to reply to the first")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"texts": ["fine", "` + strings.Repeat("a", MaxTweetLength + 1) + `"]}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 1))
+	rr = httptest.NewRecorder()
+	handler.PostThread(rr, req)
+
+	var tooLong struct {
+		Index int `json:"index"`
+	}
+	json.NewDecoder(rr.Body).Decode(&tooLong)
+	if tooLong.Index != 1 {
+		t.Errorf("expected the error to point at tweet 1, got %d", tooLong.Index)
+	}
+}
+
+func TestHandlerGetUserTweets(t *testing.T) {
+	reply := int64(1)
+	svc := NewMockTweetService()
+	svc.tweets[1] = &Tweet{ID: 1, UserID: 7, Text: "first"}
+	svc.tweets[2] = &Tweet{ID: 2, UserID: 7, Text: "a reply", InReplyToID: &reply}
+	svc.tweets[3] = &Tweet{ID: 3, UserID: 7, Kind: KindRetweet}
+	svc.tweets[4] = &Tweet{ID: 4, UserID: 8, Text: "someone else"}
+
+	handler := NewHandler(context.Background(), svc)
+
+	tests := []struct{
+		name string
+		param string
+		value string
+		query string
+		expectedStatus int
+		expectedIDs []int64
+	}{
+		{"GetUserTweets_Defaults", "userId", "7", "", http.StatusOK, []int64{3, 1}},
+		{"GetUserTweets_Replies", "userId", "7", "?include_replies=true", http.StatusOK, []int64{3, 2, 1}},
+		{"GetUserTweets_NoRetweets", "userId", "7", "?include_retweets=false", http.StatusOK, []int64{1}},
+		{"GetUserTweets_Page", "userId", "7", "?max_id=3", http.StatusOK, []int64{1}},
+		{"GetUserTweets_Username", "username", "alice", "", http.StatusOK, []int64{3, 1}},
+		{"GetUserTweets_InvalidID", "userId", "hi", "", http.StatusBadRequest, nil},
+		{"GetUserTweets_InvalidToggle", "userId", "7", "?include_replies=maybe", http.StatusBadRequest, nil},
+		{"GetUserTweets_UnknownID", "userId", "9", "", http.StatusNotFound, nil},
+		{"GetUserTweets_UnknownUsername", "username", "bob", "", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/" + tt.query, nil)
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add(tt.param, tt.value)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+
+			rr := httptest.NewRecorder()
+
+			handler.GetUserTweets(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if tt.expectedIDs == nil {
+				return
+			}
+
+			var resp FeedPage
+			json.NewDecoder(rr.Body).Decode(&resp)
+			if ids := IDs(resp.Tweets); !slices.Equal(ids, tt.expectedIDs) {
+				t.Errorf("expected tweets %v, got %v", tt.expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestHandlerPostTweetWaitsForFlush(t *testing.T) {
+	tests := []struct{
+		name string
+		postErr error
+		expectedStatus int
+	}{
+		{"PostTweet_Stored", nil, http.StatusCreated},
+		{"PostTweet_StoreFailed", gorm.ErrCheckConstraintViolated, http.StatusInternalServerError},
+		{"PostTweet_DatabaseDown", errors.New("db down"), http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// stops the worker retrying a database that stays down
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			svc := NewMockTweetService()
+			svc.postErr = tt.postErr
+			cfg := DefaultBatchConfig()
+			cfg.SyncWait = time.Second
+			handler := NewHandler(ctx, svc, WithBatching(cfg))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "hello"}`))
+			req = req.WithContext(auth.WithUserID(req.Context(), 7))
+
+			rr := httptest.NewRecorder()
+
+			handler.PostTweet(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("wrong response code, got %v want %v; %v", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if tt.postErr != nil {
+				return
+			}
+
+			var stored Tweet
+			if err := json.NewDecoder(rr.Body).Decode(&stored); err != nil {
+				t.Fatalf("expected the stored tweet in the body: %v", err)
+			}
+			if stored.ID == 0 || stored.UserID != 7 || stored.Text != "hello" {
+				t.Errorf("unexpected stored tweet %+v", stored)
+			}
+		})
+	}
+}
+
+func TestHandlerPostTweetSpool(t *testing.T) {
+	spool, err := wal.Open(filepath.Join(t.TempDir(), "tweets.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	svc := NewMockTweetService()
+	handler := NewHandler(context.Background(), svc, WithSpool(spool))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "hello"}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 7))
+
+	rr := httptest.NewRecorder()
+
+	handler.PostTweet(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("wrong response code, got %v want %v; %v", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if pending := spool.Pending(); len(pending) != 0 {
+		t.Errorf("expected the flushed tweet to be checkpointed, %d still pending", len(pending))
+	}
+	if tweet := svc.tweets[1]; tweet == nil || tweet.Text != "hello" {
+		t.Errorf("expected the tweet to be stored under the ID it was spooled with")
+	}
+}
+
+func TestHandlerReplay(t *testing.T) {
+	spool, err := wal.Open(filepath.Join(t.TempDir(), "tweets.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	// the first tweet made it into the database before the crash, the
+	// second one did not
+	svc := NewMockTweetService()
+	svc.tweets[1] = &Tweet{ID: 1, UserID: 7, Text: "first"}
+
+	for _, spooled := range []Tweet{
+		{ID: 1, UserID: 7, Text: "first", Kind: KindTweet},
+		{ID: 2, UserID: 7, Text: "second", Kind: KindTweet},
+	} {
+		payload, _ := json.Marshal(spooled)
+		if _, err := spool.Append(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := NewHandler(context.Background(), svc, WithSpool(spool))
+
+	replayed, err := handler.Replay(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replayed != 1 {
+		t.Errorf("expected 1 replayed tweet, got %d", replayed)
+	}
+	if len(svc.tweets) != 2 || svc.tweets[2].Text != "second" {
+		t.Errorf("expected only the lost tweet to be posted, got %d tweets", len(svc.tweets))
+	}
+	if pending := spool.Pending(); len(pending) != 0 {
+		t.Errorf("expected the spool to be checkpointed, %d still pending", len(pending))
+	}
+}
+
+type mockDeadLetters struct {
+	added []Tweet
+	// fail is how many more calls to Add fail
+	fail int
+}
+
+func (d *mockDeadLetters) Add(ctx context.Context, tweet Tweet, cause error) error {
+	if d.fail > 0 {
+		d.fail--
+		return errors.New("connection refused")
+	}
+	d.added = append(d.added, tweet)
+	return nil
+}
+
+func (d *mockDeadLetters) List(ctx context.Context, afterID int64, count int) ([]DeadLetter, error) {
+	return nil, nil
+}
+
+func (d *mockDeadLetters) Replay(ctx context.Context, id int64) (*Tweet, error) {
+	return nil, ErrDeadLetterNotFound
+}
+
+func (d *mockDeadLetters) Discard(ctx context.Context, id int64) error {
+	return ErrDeadLetterNotFound
+}
+
+func TestHandlerStoreRetries(t *testing.T) {
+	calls := 0
+	svc := NewMockTweetService()
+	svc.failPost = func(tweets []Tweet) error {
+		calls++
+		if len(tweets) != 2 {
+			t.Errorf("expected the batch to be retried whole, got %d tweets", len(tweets))
+		}
+		if calls < 6 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	deadLetters := &mockDeadLetters{}
+	handler := NewHandler(context.Background(), svc, WithDeadLetters(deadLetters))
+	handler.retryBackoff = time.Millisecond
+
+	batch := []pendingTweet{
+		{tweet: Tweet{UserID: 1, Text: "a"}},
+		{tweet: Tweet{UserID: 2, Text: "b"}},
+	}
+	if errs := handler.store(context.Background(), batch); errs != nil {
+		t.Fatalf("expected the batch to be stored after retrying, got %v", errs)
+	}
+
+	for _, p := range batch {
+		if p.tweet.ID == 0 {
+			t.Errorf("expected %q to be stored after retrying", p.tweet.Text)
+		}
+	}
+	if calls != 6 {
+		t.Errorf("expected the whole batch to be retried until it went through, got %d calls", calls)
+	}
+	if len(deadLetters.added) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(deadLetters.added))
+	}
+}
+
+func TestHandlerStoreDeadLetters(t *testing.T) {
+	svc := NewMockTweetService()
+	svc.failPost = func(tweets []Tweet) error {
+		for _, tweet := range tweets {
+			if tweet.Text == "poison" {
+				return &pgconn.PgError{Code: "22021", Message: "invalid byte sequence for encoding \"UTF8\""}
+			}
+		}
+		return nil
+	}
+	deadLetters := &mockDeadLetters{}
+	handler := NewHandler(context.Background(), svc, WithDeadLetters(deadLetters))
+	handler.retryBackoff = time.Millisecond
+
+	texts := []string{"a", "b", "poison", "c", "d"}
+	batch := make([]pendingTweet, len(texts))
+	for i, text := range texts {
+		batch[i] = pendingTweet{tweet: Tweet{UserID: 1, Text: text}}
+	}
+	errs := handler.store(context.Background(), batch)
+	if len(errs) != len(batch) {
+		t.Fatalf("expected an error slot per tweet, got %d", len(errs))
+	}
+
+	for i, p := range batch {
+		if p.tweet.Text == "poison" {
+			if errs[i] == nil {
+				t.Errorf("expected the poison tweet to fail")
+			}
+			continue
+		}
+		if errs[i] != nil || p.tweet.ID == 0 {
+			t.Errorf("expected %q to be stored, got %v", p.tweet.Text, errs[i])
+		}
+	}
+	if len(svc.tweets) != 4 {
+		t.Errorf("expected the other 4 tweets to be stored, got %d", len(svc.tweets))
+	}
+	if len(deadLetters.added) != 1 || deadLetters.added[0].Text != "poison" {
+		t.Errorf("expected only the poison tweet to be dead-lettered, got %+v", deadLetters.added)
+	}
+}
+
+func TestHandlerStoreStopsRetryingOnShutdown(t *testing.T) {
+	svc := NewMockTweetService()
+	svc.failPost = func(tweets []Tweet) error {
+		return errors.New("connection refused")
+	}
+	deadLetters := &mockDeadLetters{}
+	handler := NewHandler(context.Background(), svc, WithDeadLetters(deadLetters))
+	handler.retryBackoff = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	batch := []pendingTweet{{tweet: Tweet{UserID: 1, Text: "a"}}, {tweet: Tweet{UserID: 2, Text: "b"}}}
+	errs := handler.store(ctx, batch)
+	if len(errs) != 2 || errs[0] == nil || errs[1] == nil {
+		t.Errorf("expected both tweets to fail, got %v", errs)
+	}
+	if len(deadLetters.added) != 0 {
+		t.Errorf("expected an unreachable database not to dead-letter anything, got %d", len(deadLetters.added))
+	}
+}
+
+func TestHandlerFlushKeepsSpooledTweetsPending(t *testing.T) {
+	spool, err := wal.Open(filepath.Join(t.TempDir(), "tweets.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	svc := NewMockTweetService()
+	svc.failPost = func(tweets []Tweet) error {
+		return errors.New("connection refused")
+	}
+	handler := NewHandler(context.Background(), svc, WithSpool(spool))
+	handler.retryBackoff = time.Millisecond
+
+	batch := []pendingTweet{{tweet: Tweet{ID: 1, UserID: 1, Text: "a"}}, {tweet: Tweet{ID: 2, UserID: 2, Text: "b"}}}
+	for i := range batch {
+		if err := handler.spoolTweet(&batch[i]); err != nil {
+			t.Fatal(err)
+		}
+		svc.AddPending(batch[i].tweet)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	errs := handler.flush(ctx, batch)
+	for i, err := range errs {
+		if !errors.Is(err, errSpooled) {
+			t.Errorf("expected tweet %d to be left in the spool, got %v", i, err)
+		}
+	}
+	if len(svc.pending) != 2 {
+		t.Errorf("expected the spooled tweets to stay pending, got %d", len(svc.pending))
+	}
+	if pending := spool.Pending(); len(pending) != 2 {
+		t.Errorf("expected both tweets to stay spooled, got %d", len(pending))
+	}
+}
+
+func TestHandlerDeadLetterRetries(t *testing.T) {
+	spool, err := wal.Open(filepath.Join(t.TempDir(), "tweets.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	deadLetters := &mockDeadLetters{fail: 2}
+	handler := NewHandler(context.Background(), NewMockTweetService(), WithSpool(spool), WithDeadLetters(deadLetters))
+	handler.retryBackoff = time.Millisecond
+
+	p := pendingTweet{tweet: Tweet{ID: 1, UserID: 1, Text: "poison"}}
+	if err := handler.spoolTweet(&p); err != nil {
+		t.Fatal(err)
+	}
+	handler.deadLetter(context.Background(), p, errors.New("invalid byte sequence"))
+
+	if len(deadLetters.added) != 1 {
+		t.Errorf("expected the tweet to be dead-lettered once the dead letters were reachable, got %d", len(deadLetters.added))
+	}
+	if pending := spool.Pending(); len(pending) != 0 {
+		t.Errorf("expected the dead-lettered tweet to be checkpointed, %d still pending", len(pending))
+	}
+}
+
+func TestHandlerPostTweetPending(t *testing.T) {
+	svc := NewMockTweetService()
+	cfg := DefaultBatchConfig()
+	cfg.SyncWait = 0
+	handler := NewHandler(context.Background(), svc, WithBatching(cfg))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "hello"}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 7))
+	rr := httptest.NewRecorder()
+
+	handler.PostTweet(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("wrong response code, got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var tweet Tweet
+	json.NewDecoder(rr.Body).Decode(&tweet)
+	if !tweet.Pending {
+		t.Errorf("expected the accepted tweet to be marked pending")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := handler.Close(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.pending) != 0 {
+		t.Errorf("expected the tweet to stop being pending once flushed, got %v", svc.pending)
+	}
+}
+
+func TestHandlerCloseDrainsQueue(t *testing.T) {
+	svc := NewMockTweetService()
+	handler := NewHandler(context.Background(), svc)
+
+	results := make([]<-chan batch.Result[pendingTweet], 3)
+	for i := range results {
+		done, err := handler.batcher.Add(pendingTweet{tweet: Tweet{UserID: 7, Text: "queued"}})
+		if err != nil {
+			t.Fatalf("expected tweet %d to be queued: %v", i, err)
+		}
+		results[i] = done
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := handler.Close(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, done := range results {
+		select {
+		case res := <-done:
+			if res.Err != nil || res.Item.tweet.ID == 0 {
+				t.Errorf("expected tweet %d to be stored, got %+v", i, res)
+			}
+		default:
+			t.Errorf("expected tweet %d to be stored before Close returned", i)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "too late"}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 7))
+	rr := httptest.NewRecorder()
+
+	handler.PostTweet(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("wrong response code after Close, got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandlerPostTweetQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	svc := NewMockTweetService()
+	svc.failPost = func(tweets []Tweet) error {
+		<-block
+		return nil
+	}
+	cfg := DefaultBatchConfig()
+	cfg.MaxBatchSize = 1
+	cfg.QueueSize = 1
+	handler := NewHandler(context.Background(), svc, WithBatching(cfg))
+
+	// the worker is stuck on the first tweet, the second fills the queue
+	for {
+		if _, err := handler.batcher.Add(pendingTweet{tweet: Tweet{UserID: 7, Text: "queued"}}); err != nil {
+			break
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text": "hello"}`))
+	req = req.WithContext(auth.WithUserID(req.Context(), 7))
+	rr := httptest.NewRecorder()
+
+	handler.PostTweet(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("wrong response code, got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After: 1, got %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+// slowRepo stands in for a database where every insert takes a while. It
+// records the tweets in the order they were inserted.
+type slowRepo struct {
+	*mockRepo
+	mu sync.Mutex
+	delay time.Duration
+	inserted []Tweet
+}
+
+func (r *slowRepo) InsertMany(ctx context.Context, tweets []Tweet) error {
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inserted = append(r.inserted, tweets...)
+	return r.mockRepo.InsertMany(ctx, tweets)
+}
+
+func TestHandlerWorkersKeepAuthorOrder(t *testing.T) {
+	repo := &slowRepo{mockRepo: NewMockRepo(), delay: time.Millisecond}
+	cfg := DefaultBatchConfig()
+	cfg.MaxBatchSize = 5
+	cfg.Workers = 4
+	handler := NewHandler(context.Background(), NewService(context.Background(), repo), WithBatching(cfg))
+
+	// the text of a tweet is its place in the queue
+	var queued []<-chan batch.Result[pendingTweet]
+	for i := 0; i < 50; i++ {
+		p := pendingTweet{tweet: Tweet{UserID: int64(i % 7), Text: strconv.Itoa(i)}}
+		handler.svc.AssignID(&p.tweet)
+		done, err := handler.batcher.Add(p)
+		if err != nil {
+			t.Fatalf("expected tweet %d to be queued: %v", i, err)
+		}
+		queued = append(queued, done)
+	}
+	for _, done := range queued {
+		if res := <-done; res.Err != nil {
+			t.Fatalf("unexpected error: %v", res.Err)
+		}
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.inserted) != len(queued) {
+		t.Fatalf("expected %d tweets to be inserted, got %d", len(queued), len(repo.inserted))
+	}
+	// each author's tweets have to reach the repo in the order they were
+	// queued
+	last := map[int64]int{}
+	for _, tweet := range repo.inserted {
+		i, _ := strconv.Atoi(tweet.Text)
+		if prev, ok := last[tweet.UserID]; ok && i < prev {
+			t.Errorf("tweet %d of user %d was inserted after tweet %d", i, tweet.UserID, prev)
+		}
+		last[tweet.UserID] = i
+	}
+}
+
+// BenchmarkHandlerWorkers posts tweets from many authors against a repo
+// that takes 2ms per insert, to compare one flush worker with several.
+func BenchmarkHandlerWorkers(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			repo := &slowRepo{mockRepo: NewMockRepo(), delay: 2 * time.Millisecond}
+			cfg := DefaultBatchConfig()
+			cfg.MaxBatchSize = 50
+			cfg.MaxWait = 5 * time.Millisecond
+			cfg.Workers = workers
+			handler := NewHandler(context.Background(), NewService(context.Background(), repo), WithBatching(cfg))
+
+			queued := make([]<-chan batch.Result[pendingTweet], 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := pendingTweet{tweet: Tweet{UserID: int64(i % 1000), Text: "bench"}}
+				handler.svc.AssignID(&p.tweet)
+				done, err := handler.batcher.Add(p)
+				for errors.Is(err, batch.ErrFull) {
+					time.Sleep(100 * time.Microsecond)
+					done, err = handler.batcher.Add(p)
+				}
+				queued = append(queued, done)
+			}
+			for _, done := range queued {
+				<-done
+			}
+		})
+	}
+}
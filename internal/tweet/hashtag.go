@@ -0,0 +1,64 @@
+package tweet
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var hashtagFolder = cases.Fold()
+
+// TweetHashtag links a tweet to every hashtag used in its text.
+type TweetHashtag struct {
+	Tag string `gorm:"primaryKey"`
+	TweetID int64 `gorm:"primaryKey;index"`
+}
+
+// ExtractHashtags returns the distinct normalized hashtags in text, in the
+// order they first appear. A hashtag starts with '#' that does not follow a
+// word character and needs at least one letter, so "#1" is not a tag.
+func ExtractHashtags(text string) []string {
+	runes := []rune(norm.NFC.String(text))
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '＃' {
+			continue
+		}
+		if i > 0 && (isHashtagRune(runes[i-1]) || runes[i-1] == '&') {
+			continue
+		}
+
+		end := i + 1
+		hasLetter := false
+		for end < len(runes) && isHashtagRune(runes[end]) {
+			if unicode.IsLetter(runes[end]) {
+				hasLetter = true
+			}
+			end++
+		}
+		if hasLetter {
+			tag := NormalizeHashtag(string(runes[i+1 : end]))
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		i = end - 1
+	}
+	return tags
+}
+
+// NormalizeHashtag maps a hashtag, with or without its leading '#', to the
+// form it is stored and looked up by: NFC normalized and case folded.
+func NormalizeHashtag(tag string) string {
+	tag = strings.TrimLeft(tag, "#＃")
+	return norm.NFC.String(hashtagFolder.String(norm.NFC.String(tag)))
+}
+
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r) || r == '_'
+}
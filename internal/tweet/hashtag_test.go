@@ -0,0 +1,35 @@
+package tweet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct{
+		name string
+		text string
+		expected []string
+	}{
+		{"no hashtags", "hello world", []string{}},
+		{"single hashtag", "hello #world", []string{"world"}},
+		{"case insensitive", "#Go and #go and #GO", []string{"go"}},
+		{"precomposed and decomposed accents", "#Café vs #café", []string{"café"}},
+		{"non-latin scripts", "#東京 #Москва", []string{"東京", "москва"}},
+		{"stops at punctuation", "#one, #two! (#three)", []string{"one", "two", "three"}},
+		{"digits only is not a tag", "#1 #2024 #2024olympics", []string{"2024olympics"}},
+		{"inside a word", "foo#bar", []string{}},
+		{"html entity", "&#39;", []string{}},
+		{"underscores", "#snake_case", []string{"snake_case"}},
+		{"fullwidth hash", "＃Tokyo", []string{"tokyo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractHashtags(tt.text)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ExtractHashtags(%q) = %q, want %q", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package tweet
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// URLLength is the weight of every URL, whatever its actual length, as
+// links are shortened when displayed.
+const URLLength = 23
+
+// urlPattern matches links the way clients linkify them: with a scheme or
+// starting with "www.".
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// lightRanges are the code points that weigh 1, Latin and most other
+// alphabetic scripts plus common punctuation. Everything else, CJK and
+// emoji included, weighs 2.
+var lightRanges = [][2]rune{
+	{0x0000, 0x10FF},
+	{0x2000, 0x200D},
+	{0x2010, 0x201F},
+	{0x2032, 0x2037},
+}
+
+// TextTooLongError is returned by ValidateText when the weighted length of
+// a text exceeds MaxTweetLength. It matches ErrTextTooLong with errors.Is.
+type TextTooLongError struct {
+	Length int
+}
+
+func (e *TextTooLongError) Error() string {
+	return fmt.Sprintf("%s: %d of %d", ErrTextTooLong, e.Length, MaxTweetLength)
+}
+
+func (e *TextTooLongError) Is(target error) bool {
+	return target == ErrTextTooLong
+}
+
+// WeightedLength is the length of text as counted against MaxTweetLength.
+// The text is NFC normalized and split into grapheme clusters, so a letter
+// with combining accents or an emoji sequence counts once. Each cluster
+// weighs 1 or 2 depending on its first code point, and each URL weighs
+// URLLength.
+func WeightedLength(text string) int {
+	text = norm.NFC.String(text)
+
+	length := 0
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		url := strings.TrimRight(text[loc[0]:loc[1]], `.,;:!?'")]}`)
+		length += weigh(text[last:loc[0]]) + URLLength
+		last = loc[0] + len(url)
+	}
+	return length + weigh(text[last:])
+}
+
+func weigh(text string) int {
+	length := 0
+	g := uniseg.NewGraphemes(text)
+	for g.Next() {
+		length += runeWeight(g.Runes()[0])
+	}
+	return length
+}
+
+func runeWeight(r rune) int {
+	for _, lr := range lightRanges {
+		if r >= lr[0] && r <= lr[1] {
+			return 1
+		}
+	}
+	return 2
+}
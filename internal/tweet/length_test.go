@@ -0,0 +1,73 @@
+package tweet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWeightedLength(t *testing.T) {
+	tests := []struct{
+		name string
+		text string
+		expected int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello world", 11},
+		{"precomposed accent", "café", 4},
+		{"combining accent", "café", 4},
+		{"cjk weighs double", "東京", 4},
+		{"hangul weighs double", "안녕", 4},
+		{"emoji weighs double", "👍", 2},
+		{"emoji with skin tone is one cluster", "👍🏽", 2},
+		{"family emoji is one cluster", "👨‍👩‍👧", 2},
+		{"flag is one cluster", "🇩🇪", 2},
+		{"url has a fixed weight", "https://example.com/a/very/long/path?with=query", URLLength},
+		{"url with text around it", "see https://example.com.", 4 + URLLength + 1},
+		{"www url", "www.example.com", URLLength},
+		{"two urls", "http://a.io http://b.io", 2*URLLength + 1},
+		{"curly quotes weigh one", "“hi”", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WeightedLength(tt.text); got != tt.expected {
+				t.Errorf("WeightedLength(%q) = %d, want %d", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateTextLength(t *testing.T) {
+	tests := []struct{
+		name string
+		text string
+		expectedLength int
+	}{
+		{"ascii at the limit", strings.Repeat("a", MaxTweetLength), 0},
+		{"ascii over the limit", strings.Repeat("a", MaxTweetLength + 1), MaxTweetLength + 1},
+		{"cjk at the limit", strings.Repeat("字", MaxTweetLength / 2), 0},
+		{"cjk over the limit", strings.Repeat("字", MaxTweetLength / 2 + 1), MaxTweetLength + 2},
+		{"long url is short", strings.Repeat("a", 250) + " https://example.com/" + strings.Repeat("x", 100), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateText(tt.text)
+			if tt.expectedLength == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var tooLong *TextTooLongError
+			if !errors.As(err, &tooLong) || !errors.Is(err, ErrTextTooLong) {
+				t.Fatalf("expected %v got %v", ErrTextTooLong, err)
+			}
+			if tooLong.Length != tt.expectedLength {
+				t.Errorf("expected length %d got %d", tt.expectedLength, tooLong.Length)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package tweet
+
+import (
+	"context"
+
+	"github.com/daniiltsioma/twitter/internal/media"
+)
+
+const MaxMediaPerTweet = 4
+
+// TweetMedia attaches uploaded media to a tweet, Position keeps the order
+// the author listed them in.
+type TweetMedia struct {
+	TweetID int64 `gorm:"primaryKey"`
+	MediaID int64 `gorm:"primaryKey"`
+	Position int
+}
+
+// MediaLookup fetches uploaded media, media.MediaService satisfies it.
+type MediaLookup interface {
+	GetMany(ctx context.Context, mediaIDs []int64) ([]media.Media, error)
+}
+
+// checkMedia makes sure every ID refers to media uploaded by userId and
+// returns the IDs without duplicates.
+func checkMedia(ctx context.Context, lookup MediaLookup, userId int64, mediaIDs []int64) ([]int64, error) {
+	ids := []int64{}
+	seen := map[int64]bool{}
+	for _, id := range mediaIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > MaxMediaPerTweet {
+		return nil, ErrTooManyMedia
+	}
+	if lookup == nil {
+		return nil, ErrMediaNotOwned
+	}
+
+	found, err := lookup.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := map[int64]bool{}
+	for _, m := range found {
+		if m.UserID == userId {
+			owned[m.ID] = true
+		}
+	}
+	for _, id := range ids {
+		if !owned[id] {
+			return nil, ErrMediaNotOwned
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,123 @@
+package tweet
+
+import (
+	"context"
+	"log"
+	"unicode"
+
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+// maxMentions caps how many distinct usernames of one tweet get resolved.
+const maxMentions = 20
+
+// Mention is a resolved @username in the text of a tweet. Start and End are
+// code point offsets into the text, End is exclusive and covers the '@'.
+type Mention struct {
+	TweetID int64 `json:"-" gorm:"primaryKey;index:idx_mention_user,priority:2"`
+	Start int `json:"start" gorm:"primaryKey"`
+	End int `json:"end"`
+	UserID int64 `json:"userId" gorm:"index:idx_mention_user,priority:1"`
+	Username string `json:"username"`
+}
+
+// UserLookup resolves mentioned usernames, user.UserService satisfies it.
+type UserLookup interface {
+	GetByUsernames(ctx context.Context, usernames []string) ([]user.User, error)
+}
+
+// ExtractMentions finds every @username in text. The returned mentions
+// carry offsets and usernames but no user IDs yet.
+func ExtractMentions(text string) []Mention {
+	runes := []rune(text)
+
+	mentions := []Mention{}
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' && runes[i] != '＠' {
+			continue
+		}
+		if i > 0 && (isUsernameRune(runes[i-1]) || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		if end > i+1 {
+			mentions = append(mentions, Mention{
+				Start: i,
+				End: end,
+				Username: string(runes[i+1 : end]),
+			})
+		}
+		i = end - 1
+	}
+	return mentions
+}
+
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// resolveMentions looks up every mentioned username once. Unknown
+// usernames are dropped and stay plain text.
+func resolveMentions(ctx context.Context, users UserLookup, text string) []Mention {
+	return resolveAllMentions(ctx, users, []string{text})[0]
+}
+
+// resolveAllMentions resolves the mentions of each of texts with a single
+// lookup for all of them.
+func resolveAllMentions(ctx context.Context, users UserLookup, texts []string) [][]Mention {
+	all := make([][]Mention, len(texts))
+	if users == nil {
+		return all
+	}
+
+	usernames := []string{}
+	seen := map[string]bool{}
+	for i, text := range texts {
+		// only the first maxMentions distinct usernames of a tweet count
+		distinct := map[string]bool{}
+		for _, m := range ExtractMentions(text) {
+			if !distinct[m.Username] {
+				if len(distinct) == maxMentions {
+					continue
+				}
+				distinct[m.Username] = true
+			}
+			if !seen[m.Username] {
+				seen[m.Username] = true
+				usernames = append(usernames, m.Username)
+			}
+			all[i] = append(all[i], m)
+		}
+	}
+	if len(usernames) == 0 {
+		return all
+	}
+
+	resolved := map[string]int64{}
+	found, err := users.GetByUsernames(ctx, usernames)
+	if err != nil {
+		log.Printf("could not resolve mentions of %v: %v", usernames, err)
+	}
+	for _, u := range found {
+		resolved[u.Username] = u.ID
+	}
+
+	for i, candidates := range all {
+		if candidates == nil {
+			continue
+		}
+		mentions := []Mention{}
+		for _, m := range candidates {
+			if userId, ok := resolved[m.Username]; ok {
+				m.UserID = userId
+				mentions = append(mentions, m)
+			}
+		}
+		all[i] = mentions
+	}
+	return all
+}
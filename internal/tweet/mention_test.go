@@ -0,0 +1,107 @@
+package tweet
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+type mockUserLookup struct {
+	users map[string]int64
+	calls int
+	// err fails every lookup by username
+	err error
+}
+
+func (l *mockUserLookup) GetByUsername(ctx context.Context, username string) (*user.User, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, l.err
+	}
+	id, ok := l.users[username]
+	if !ok {
+		return nil, user.ErrUserNotFound
+	}
+	return &user.User{ID: id, Username: username}, nil
+}
+
+func (l *mockUserLookup) GetByUsernames(ctx context.Context, usernames []string) ([]user.User, error) {
+	l.calls++
+	users := []user.User{}
+	for _, username := range usernames {
+		if id, ok := l.users[username]; ok {
+			users = append(users, user.User{ID: id, Username: username})
+		}
+	}
+	return users, nil
+}
+
+func (l *mockUserLookup) GetByIDs(ctx context.Context, userIds []int64) ([]user.User, error) {
+	users := []user.User{}
+	for username, id := range l.users {
+		for _, want := range userIds {
+			if id == want {
+				users = append(users, user.User{ID: id, Username: username})
+			}
+		}
+	}
+	return users, nil
+}
+
+func TestResolveMentions(t *testing.T) {
+	tests := []struct{
+		name string
+		text string
+		expected []Mention
+		expectedCalls int
+	}{
+		{"no mentions", "hello world", nil, 0},
+		{"known user", "hi @alice!", []Mention{{Start: 3, End: 9, UserID: 1, Username: "alice"}}, 1},
+		{"unknown user stays plain text", "hi @mallory", []Mention{}, 1},
+		{"offsets count code points", "héllo 東京 @bob", []Mention{{Start: 9, End: 13, UserID: 2, Username: "bob"}}, 1},
+		{"repeated mention is looked up once", "@alice @alice", []Mention{
+			{Start: 0, End: 6, UserID: 1, Username: "alice"},
+			{Start: 7, End: 13, UserID: 1, Username: "alice"},
+		}, 1},
+		{"different users are looked up together", "@alice @bob @mallory", []Mention{
+			{Start: 0, End: 6, UserID: 1, Username: "alice"},
+			{Start: 7, End: 11, UserID: 2, Username: "bob"},
+		}, 1},
+		{"email address is not a mention", "mail bob@example.com", nil, 0},
+		{"bare at sign", "meet @ noon", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &mockUserLookup{users: map[string]int64{"alice": 1, "bob": 2}}
+
+			got := resolveMentions(context.Background(), users, tt.text)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("resolveMentions(%q) = %+v, want %+v", tt.text, got, tt.expected)
+			}
+			if users.calls != tt.expectedCalls {
+				t.Errorf("expected %d lookups got %d", tt.expectedCalls, users.calls)
+			}
+		})
+	}
+}
+
+func TestResolveAllMentions(t *testing.T) {
+	users := &mockUserLookup{users: map[string]int64{"alice": 1, "bob": 2}}
+
+	got := resolveAllMentions(context.Background(), users, []string{"hi @alice", "no mentions", "@bob and @alice", "@mallory"})
+	expected := [][]Mention{
+		{{Start: 3, End: 9, UserID: 1, Username: "alice"}},
+		nil,
+		{{Start: 0, End: 4, UserID: 2, Username: "bob"}, {Start: 9, End: 15, UserID: 1, Username: "alice"}},
+		{},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v got %+v", expected, got)
+	}
+	if users.calls != 1 {
+		t.Errorf("expected the usernames of all texts to be looked up at once, got %d lookups", users.calls)
+	}
+}
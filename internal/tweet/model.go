@@ -1,10 +1,87 @@
 package tweet
 
-import "time"
+import (
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/media"
+	"gorm.io/gorm"
+)
+
+// DeletedText replaces the text of deleted tweets that are still
+// referenced by replies, retweets or quotes.
+const DeletedText = "This tweet was deleted"
+
+type Kind string
+
+const (
+	KindTweet Kind = "tweet"
+	// KindRetweet reshares OriginalID as is, without any text of its own.
+	KindRetweet Kind = "retweet"
+	// KindQuote carries its own text with OriginalID embedded below it.
+	KindQuote Kind = "quote"
+)
 
 type Tweet struct {
-	ID int64 `gorm:"primaryKey"`
-	UserID int64 `json:"userId" gorm:"index:idx_user_created,priority:1"`
+	// ID is a snowflake ID assigned before the tweet is stored, so IDs
+	// sort the same as CreatedAt. It is served as a string, like every
+	// tweet ID, as it does not fit the numbers of JavaScript clients.
+	ID int64 `json:"id,string" gorm:"primaryKey;autoIncrement:false;index:idx_user_tweets,priority:2"`
+	UserID int64 `json:"userId" gorm:"index:idx_user_tweets,priority:1;uniqueIndex:idx_user_retweet,priority:1,where:kind = 'retweet'"`
+	Text string `json:"text"`
+	Kind Kind `json:"kind" gorm:"not null;default:tweet"`
+	OriginalID *int64 `json:"originalId,string,omitempty" gorm:"index;uniqueIndex:idx_user_retweet,priority:2,where:kind = 'retweet'"`
+	Original *Tweet `json:"original,omitempty" gorm:"-"`
+	InReplyToID *int64 `json:"inReplyToId,string,omitempty" gorm:"index"`
+	ConversationID int64 `json:"conversationId,string" gorm:"index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Deleted bool `json:"deleted,omitempty" gorm:"-"`
+	// Pending marks a tweet that was accepted but is not stored yet, only
+	// its author gets to see it in that state
+	Pending bool `json:"pending,omitempty" gorm:"-"`
+	EditedAt *time.Time `json:"editedAt,omitempty"`
+	RevisionCount int `json:"revisionCount"`
+	Mentions []Mention `json:"mentions,omitempty" gorm:"-"`
+	MediaIDs []int64 `json:"mediaIds,omitempty" gorm:"-"`
+	Media []media.Media `json:"media,omitempty" gorm:"-"`
+
+	LikeCount int64 `json:"likeCount" gorm:"-"`
+	LikedByMe bool `json:"likedByMe" gorm:"-"`
+}
+
+// Revision is a previous text of an edited tweet. CreatedAt is when that
+// text was written, ReplacedAt when an edit superseded it.
+type Revision struct {
+	ID int64 `json:"id" gorm:"primaryKey"`
+	TweetID int64 `json:"tweetId,string" gorm:"index"`
 	Text string `json:"text"`
-	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_user_created,priority:2"`
-}
\ No newline at end of file
+	CreatedAt time.Time `json:"createdAt"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// tombstone strips a deleted tweet down to what is needed to keep threads
+// and embeds intact.
+func tombstone(t Tweet) Tweet {
+	return Tweet{
+		ID: t.ID,
+		Kind: t.Kind,
+		Text: DeletedText,
+		InReplyToID: t.InReplyToID,
+		ConversationID: t.ConversationID,
+		CreatedAt: t.CreatedAt,
+		Deleted: true,
+	}
+}
+
+// Thread is a tweet together with the chain of tweets it replies to
+// and the tree of replies below it.
+type Thread struct {
+	Ancestors []Tweet `json:"ancestors"`
+	Tweet Tweet `json:"tweet"`
+	Replies []*ThreadNode `json:"replies"`
+}
+
+type ThreadNode struct {
+	Tweet
+	Replies []*ThreadNode `json:"replies"`
+}
@@ -0,0 +1,85 @@
+package tweet
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// outboxInterval is how often the outbox is checked for tweets the
+	// listeners have not been told about, in case a nudge went missing or
+	// a listener failed
+	outboxInterval = time.Second
+	outboxBatchSize = 500
+)
+
+// OutboxEntry marks a stored tweet whose listeners have not been told about
+// it yet. It is written in the transaction that stores the tweet and only
+// deleted once every listener is done, so a crash in between does not lose
+// the fan-out.
+type OutboxEntry struct {
+	TweetID int64 `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt time.Time
+}
+
+// notify wakes up relay after tweets were stored.
+func (s *tweetService) notify() {
+	select {
+	case s.nudge <- struct{}{}:
+	default:
+	}
+}
+
+// relay tells the listeners about the tweets in the outbox, off the path
+// that stores them, until ctx is done.
+func (s *tweetService) relay(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.nudge:
+		}
+
+		for {
+			n, err := s.relayBatch(ctx)
+			if err != nil {
+				log.Printf("could not relay stored tweets, retrying in %v: %v", outboxInterval, err)
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relayBatch relays the oldest tweets in the outbox and returns how many
+// there were. A listener that fails gets the same tweets again, as do the
+// ones before it, so listeners have to cope with seeing a tweet twice.
+func (s *tweetService) relayBatch(ctx context.Context) (int, error) {
+	ids, err := s.repo.GetOutbox(ctx, outboxBatchSize)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	if len(s.listeners) > 0 {
+		// tweets deleted in the meantime are passed on all the same, reads
+		// leave them out
+		tweets, err := s.repo.GetTweets(ctx, ids)
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range s.listeners {
+			if err := l.Stored(ctx, tweets); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(ids), s.repo.DeleteOutbox(ctx, ids)
+}
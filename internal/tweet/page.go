@@ -0,0 +1,100 @@
+package tweet
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Page selects a window of a feed that is ordered newest first. MaxID and
+// SinceID are exclusive bounds on the tweet ID, 0 leaves them open. Since
+// IDs are unique and sort the same as CreatedAt, tweets posted in the same
+// instant still end up on exactly one page.
+type Page struct {
+	MaxID int64
+	SinceID int64
+	Count int
+}
+
+// Forward reports whether the page is read up from SinceID rather than down
+// from the newest tweet, so paging back towards newer tweets leaves no gap.
+func (p Page) Forward() bool {
+	return p.SinceID > 0 && p.MaxID == 0
+}
+
+// Contains reports whether id lies within the bounds of the page.
+func (p Page) Contains(id int64) bool {
+	return (p.MaxID == 0 || id < p.MaxID) && id > p.SinceID
+}
+
+// Trim cuts tweets, newest first, down to Count from the end the page is
+// read from.
+func (p Page) Trim(tweets []Tweet) []Tweet {
+	if len(tweets) <= p.Count {
+		return tweets
+	}
+	if p.Forward() {
+		return tweets[len(tweets)-p.Count:]
+	}
+	return tweets[:p.Count]
+}
+
+// FeedPage is one page of a feed as it is served. NextCursor is the max_id
+// of the page of older tweets, left out when there are none. PrevCursor is
+// the since_id to poll for newer tweets with.
+type FeedPage struct {
+	Tweets []Tweet `json:"tweets"`
+	NextCursor int64 `json:"next_cursor,string,omitempty"`
+	PrevCursor int64 `json:"prev_cursor,string,omitempty"`
+}
+
+// NewFeedPage sets the cursors of tweets read for page. ids are the IDs as
+// fetched, before tweets were dropped from the page, so a tweet dropped at
+// the edge of a page is not fetched again with the next one.
+func NewFeedPage(ids []int64, tweets []Tweet, page Page) *FeedPage {
+	out := &FeedPage{
+		Tweets: tweets,
+		PrevCursor: page.SinceID,
+	}
+	if len(tweets) > 0 {
+		out.PrevCursor = tweets[0].ID
+	}
+	if len(ids) == page.Count {
+		out.NextCursor = ids[len(ids)-1]
+	}
+	return out
+}
+
+// IDs returns the IDs of tweets in order.
+func IDs(tweets []Tweet) []int64 {
+	ids := make([]int64, len(tweets))
+	for i, t := range tweets {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// PageFromRequest reads the max_id, since_id and count query parameters of
+// a feed.
+func PageFromRequest(r *http.Request) (Page, error) {
+	p := Page{Count: defaultPageSize}
+
+	var err error
+	if v := r.URL.Query().Get("count"); v != "" {
+		if p.Count, err = strconv.Atoi(v); err != nil || p.Count <= 0 {
+			return Page{}, errors.New("count must be a positive integer")
+		}
+	}
+	if v := r.URL.Query().Get("max_id"); v != "" {
+		if p.MaxID, err = strconv.ParseInt(v, 10, 64); err != nil || p.MaxID <= 0 {
+			return Page{}, errors.New("max_id must be a positive integer")
+		}
+	}
+	if v := r.URL.Query().Get("since_id"); v != "" {
+		if p.SinceID, err = strconv.ParseInt(v, 10, 64); err != nil || p.SinceID <= 0 {
+			return Page{}, errors.New("since_id must be a positive integer")
+		}
+	}
+	p.Count = min(p.Count, maxPageSize)
+	return p, nil
+}
@@ -0,0 +1,119 @@
+package tweet
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestPageFromRequest(t *testing.T) {
+	tests := []struct{
+		query string
+		expected Page
+		expectError bool
+	}{
+		{"", Page{Count: defaultPageSize}, false},
+		{"?count=5&max_id=10", Page{MaxID: 10, Count: 5}, false},
+		{"?since_id=3", Page{SinceID: 3, Count: defaultPageSize}, false},
+		{"?count=1000", Page{Count: maxPageSize}, false},
+		{"?count=0", Page{}, true},
+		{"?max_id=abc", Page{}, true},
+		{"?since_id=-1", Page{}, true},
+	}
+
+	for _, tt := range tests {
+		page, err := PageFromRequest(httptest.NewRequest("GET", "/" + tt.query, nil))
+		if (err != nil) != tt.expectError {
+			t.Errorf("%q: unexpected error %v", tt.query, err)
+		}
+		if page != tt.expected {
+			t.Errorf("%q: expected %+v got %+v", tt.query, tt.expected, page)
+		}
+	}
+}
+
+func TestPageTrim(t *testing.T) {
+	tweets := []Tweet{{ID: 5}, {ID: 4}, {ID: 3}, {ID: 2}}
+	ids := func(tweets []Tweet) []int64 {
+		out := []int64{}
+		for _, t := range tweets {
+			out = append(out, t.ID)
+		}
+		return out
+	}
+
+	if got := ids(Page{Count: 2}.Trim(tweets)); !slices.Equal(got, []int64{5, 4}) {
+		t.Errorf("expected the newest tweets, got %v", got)
+	}
+	// reading up from since_id keeps the tweets right above it
+	if got := ids(Page{SinceID: 1, Count: 2}.Trim(tweets)); !slices.Equal(got, []int64{3, 2}) {
+		t.Errorf("expected the oldest tweets, got %v", got)
+	}
+}
+
+func TestNewFeedPage(t *testing.T) {
+	tests := []struct{
+		name string
+		ids []int64
+		tweets []Tweet
+		page Page
+		expectedNext int64
+		expectedPrev int64
+	}{
+		{"full page", []int64{5, 4}, []Tweet{{ID: 5}, {ID: 4}}, Page{Count: 2}, 4, 5},
+		{"last page", []int64{3}, []Tweet{{ID: 3}}, Page{MaxID: 4, Count: 2}, 0, 3},
+		// the tweet dropped at the edge still moves the cursor past it
+		{"dropped tweet", []int64{5, 4}, []Tweet{{ID: 5}}, Page{Count: 2}, 4, 5},
+		{"nothing newer", []int64{}, []Tweet{}, Page{SinceID: 7, Count: 2}, 0, 7},
+	}
+
+	for _, tt := range tests {
+		got := NewFeedPage(tt.ids, tt.tweets, tt.page)
+		if got.NextCursor != tt.expectedNext || got.PrevCursor != tt.expectedPrev {
+			t.Errorf("%s: expected cursors %d/%d got %d/%d", tt.name, tt.expectedNext, tt.expectedPrev, got.NextCursor, got.PrevCursor)
+		}
+	}
+}
+
+func TestFeedPageJSON(t *testing.T) {
+	// past 2^53, the largest integer a JavaScript number holds exactly
+	id := int64(1)<<58 + 1
+	replyTo := id - 1
+	page := FeedPage{
+		Tweets: []Tweet{{ID: id, InReplyToID: &replyTo, OriginalID: &replyTo, ConversationID: replyTo}},
+		NextCursor: id,
+		PrevCursor: id,
+	}
+
+	data, err := json.Marshal(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct{
+		Tweets []map[string]interface{} `json:"tweets"`
+		NextCursor interface{} `json:"next_cursor"`
+		PrevCursor interface{} `json:"prev_cursor"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{raw.Tweets[0]["id"], raw.Tweets[0]["inReplyToId"], raw.Tweets[0]["originalId"], raw.Tweets[0]["conversationId"], raw.NextCursor, raw.PrevCursor} {
+		if _, ok := v.(string); !ok {
+			t.Errorf("expected IDs to be served as strings, got %v in %s", v, data)
+		}
+	}
+
+	var got FeedPage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	tweet := got.Tweets[0]
+	if tweet.ID != id || *tweet.InReplyToID != replyTo || *tweet.OriginalID != replyTo || tweet.ConversationID != replyTo {
+		t.Errorf("expected the IDs to round-trip, got %+v", tweet)
+	}
+	if got.NextCursor != id || got.PrevCursor != id {
+		t.Errorf("expected the cursors to round-trip, got %d/%d", got.NextCursor, got.PrevCursor)
+	}
+}
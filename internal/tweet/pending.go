@@ -0,0 +1,94 @@
+package tweet
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// pendingSet holds tweets that were accepted but not flushed yet, by
+// author, so authors can read their own tweets back right away.
+type pendingSet struct {
+	mu sync.RWMutex
+	byUser map[int64]map[int64]Tweet
+}
+
+func newPendingSet() *pendingSet {
+	return &pendingSet{byUser: map[int64]map[int64]Tweet{}}
+}
+
+func (p *pendingSet) add(tweet Tweet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tweets, ok := p.byUser[tweet.UserID]
+	if !ok {
+		tweets = map[int64]Tweet{}
+		p.byUser[tweet.UserID] = tweets
+	}
+	tweet.Pending = true
+	tweets[tweet.ID] = tweet
+}
+
+func (p *pendingSet) remove(tweets []Tweet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range tweets {
+		delete(p.byUser[t.UserID], t.ID)
+		if len(p.byUser[t.UserID]) == 0 {
+			delete(p.byUser, t.UserID)
+		}
+	}
+}
+
+func (p *pendingSet) get(userId, tweetID int64) (Tweet, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	tweet, ok := p.byUser[userId][tweetID]
+	return tweet, ok
+}
+
+// list returns the pending tweets of userId, newest first.
+func (p *pendingSet) list(userId int64) []Tweet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	tweets := make([]Tweet, 0, len(p.byUser[userId]))
+	for _, t := range p.byUser[userId] {
+		tweets = append(tweets, t)
+	}
+	slices.SortFunc(tweets, func(a, b Tweet) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return tweets
+}
+
+// mergePending merges the pending tweets into stored, both newest first.
+// Pending tweets that have been stored in the meantime are left out, the
+// stored row wins.
+func mergePending(stored, pending []Tweet) []Tweet {
+	if len(pending) == 0 {
+		return stored
+	}
+
+	ids := make(map[int64]bool, len(stored))
+	for _, t := range stored {
+		ids[t.ID] = true
+	}
+
+	merged := make([]Tweet, 0, len(stored)+len(pending))
+	i := 0
+	for _, p := range pending {
+		if ids[p.ID] {
+			continue
+		}
+		for i < len(stored) && stored[i].ID > p.ID {
+			merged = append(merged, stored[i])
+			i++
+		}
+		merged = append(merged, p)
+	}
+	return append(merged, stored[i:]...)
+}
@@ -0,0 +1,32 @@
+package tweet
+
+import (
+	"context"
+
+	"github.com/daniiltsioma/twitter/internal/user"
+)
+
+// ProfileLookup finds the user whose profile is read, user.UserService
+// satisfies it.
+type ProfileLookup interface {
+	GetByUsername(ctx context.Context, username string) (*user.User, error)
+	GetByIDs(ctx context.Context, userIds []int64) ([]user.User, error)
+}
+
+// ProfileFilter picks which of a user's tweets show on their profile
+// besides the tweets they started.
+type ProfileFilter struct {
+	Replies bool
+	Retweets bool
+}
+
+// Allows reports whether t shows on a profile under the filter.
+func (f ProfileFilter) Allows(t Tweet) bool {
+	if t.InReplyToID != nil && !f.Replies {
+		return false
+	}
+	if t.Kind == KindRetweet && !f.Retweets {
+		return false
+	}
+	return true
+}
@@ -3,16 +3,45 @@ package tweet
 import (
 	"context"
 	"log"
+	"slices"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type TweetRepo interface {
 	InsertTweet(ctx context.Context, tweet *Tweet) error
 	InsertMany(ctx context.Context, tweets []Tweet) error
+	// GetTweet, GetTweets and GetConversation include soft-deleted tweets,
+	// it is up to the caller to render them as tombstones.
 	GetTweet(ctx context.Context, tweetID int64) (*Tweet, error)
+	GetTweets(ctx context.Context, tweetIDs []int64) ([]Tweet, error)
 
-	GetTweetsFromUsers(ctx context.Context, userIds []int64) ([]Tweet, error)
+	InsertRetweet(ctx context.Context, retweet *Tweet) error
+	DeleteRetweet(ctx context.Context, userId, originalID int64) error
+	DeleteTweet(ctx context.Context, tweetID int64) error
+
+	UpdateText(ctx context.Context, tweet *Tweet, text string, mentions []Mention, editedAt time.Time) error
+	GetRevisions(ctx context.Context, tweetID int64) ([]Revision, error)
+
+	// GetTweetsFromUsers returns a page of the tweets of userIds, newest
+	// first.
+	GetTweetsFromUsers(ctx context.Context, userIds []int64, page Page) ([]Tweet, error)
+	// GetTweetsByUser returns a page of the tweets of userId that filter
+	// allows, newest first.
+	GetTweetsByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error)
+	GetConversation(ctx context.Context, conversationID int64) ([]Tweet, error)
+	GetTweetsByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error)
+
+	GetMentions(ctx context.Context, tweetIDs []int64) ([]Mention, error)
+	GetAttachments(ctx context.Context, tweetIDs []int64) ([]TweetMedia, error)
+	GetTweetsMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error)
+
+	// GetOutbox returns the IDs of up to count tweets in the outbox, oldest
+	// first. InsertMany and InsertRetweet put the tweets they store there.
+	GetOutbox(ctx context.Context, count int) ([]int64, error)
+	DeleteOutbox(ctx context.Context, tweetIDs []int64) error
 }
 
 type tweetRepo struct {
@@ -23,6 +52,12 @@ func NewRepo(db *gorm.DB) *tweetRepo {
 	return &tweetRepo{db: db}
 }
 
+// unscoped makes a query see soft-deleted tweets, and makes Delete remove
+// rows for good.
+func unscoped(stmt *gorm.Statement) {
+	stmt.Unscoped = true
+}
+
 func (r *tweetRepo) InsertTweet(ctx context.Context, tweet *Tweet) error {
 	if err := gorm.G[Tweet](r.db, gorm.WithResult()).Create(ctx, tweet); err != nil {
 		log.Printf("could not insert tweet for userId=%d: %v", tweet.UserID, err)
@@ -32,15 +67,165 @@ func (r *tweetRepo) InsertTweet(ctx context.Context, tweet *Tweet) error {
 }
 
 func (r *tweetRepo) InsertMany(ctx context.Context, tweets []Tweet) error {
-	if err := gorm.G[Tweet](r.db, gorm.WithResult()).CreateInBatches(ctx, &tweets, len(tweets)); err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[Tweet](tx, gorm.WithResult()).CreateInBatches(ctx, &tweets, len(tweets)); err != nil {
+			return err
+		}
+		if err := assignConversations(ctx, tx, tweets); err != nil {
+			return err
+		}
+		if err := insertHashtags(ctx, tx, tweets); err != nil {
+			return err
+		}
+		if err := insertMentions(ctx, tx, tweets); err != nil {
+			return err
+		}
+		if err := insertAttachments(ctx, tx, tweets); err != nil {
+			return err
+		}
+		return insertOutbox(ctx, tx, tweets)
+	})
+	if err != nil {
 		log.Printf("could not batch insert tweets: %v", err)
 		return err
 	}
 	return nil
 }
 
+func insertHashtags(ctx context.Context, tx *gorm.DB, tweets []Tweet) error {
+	rows := []TweetHashtag{}
+	for _, t := range tweets {
+		for _, tag := range ExtractHashtags(t.Text) {
+			rows = append(rows, TweetHashtag{Tag: tag, TweetID: t.ID})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return gorm.G[TweetHashtag](tx).CreateInBatches(ctx, &rows, len(rows))
+}
+
+// insertMentions stores the mentions resolved before the tweets were queued.
+func insertMentions(ctx context.Context, tx *gorm.DB, tweets []Tweet) error {
+	rows := []Mention{}
+	for _, t := range tweets {
+		for _, m := range t.Mentions {
+			m.TweetID = t.ID
+			rows = append(rows, m)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return gorm.G[Mention](tx).CreateInBatches(ctx, &rows, len(rows))
+}
+
+func insertAttachments(ctx context.Context, tx *gorm.DB, tweets []Tweet) error {
+	rows := []TweetMedia{}
+	for _, t := range tweets {
+		for i, id := range t.MediaIDs {
+			rows = append(rows, TweetMedia{TweetID: t.ID, MediaID: id, Position: i})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return gorm.G[TweetMedia](tx).CreateInBatches(ctx, &rows, len(rows))
+}
+
+// insertOutbox puts freshly stored tweets in the outbox, for relay to pass
+// on to the listeners.
+func insertOutbox(ctx context.Context, tx *gorm.DB, tweets []Tweet) error {
+	rows := make([]OutboxEntry, len(tweets))
+	for i, t := range tweets {
+		rows[i] = OutboxEntry{TweetID: t.ID}
+	}
+	return gorm.G[OutboxEntry](tx).CreateInBatches(ctx, &rows, len(rows))
+}
+
+// assignConversations sets ConversationID on freshly inserted tweets. Roots
+// start their own conversation, replies inherit it from their parent, which
+// may either be stored already or be part of the same batch.
+func assignConversations(ctx context.Context, tx *gorm.DB, tweets []Tweet) error {
+	inBatch := make(map[int64]int, len(tweets))
+	for i, t := range tweets {
+		inBatch[t.ID] = i
+	}
+
+	parentIds := []int64{}
+	for _, t := range tweets {
+		if t.InReplyToID == nil {
+			continue
+		}
+		if _, ok := inBatch[*t.InReplyToID]; !ok {
+			parentIds = append(parentIds, *t.InReplyToID)
+		}
+	}
+
+	stored := map[int64]int64{}
+	if len(parentIds) > 0 {
+		parents, err := gorm.G[Tweet](tx).Scopes(unscoped).Select("id", "conversation_id").Where("id IN ?", parentIds).Find(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			stored[p.ID] = p.ConversationID
+		}
+	}
+
+	var resolve func(i int, depth int) int64
+	resolve = func(i int, depth int) int64 {
+		t := &tweets[i]
+		if t.ConversationID != 0 {
+			return t.ConversationID
+		}
+		switch {
+		case t.InReplyToID == nil:
+			t.ConversationID = t.ID
+		case depth > len(tweets):
+			// a reply cycle inside one batch, fall back to the parent
+			t.ConversationID = *t.InReplyToID
+		default:
+			if j, ok := inBatch[*t.InReplyToID]; ok {
+				t.ConversationID = resolve(j, depth+1)
+			} else if conv, ok := stored[*t.InReplyToID]; ok && conv != 0 {
+				t.ConversationID = conv
+			} else {
+				t.ConversationID = *t.InReplyToID
+			}
+		}
+		return t.ConversationID
+	}
+
+	roots := []int64{}
+	replies := map[int64][]int64{}
+	for i := range tweets {
+		conv := resolve(i, 0)
+		if conv == tweets[i].ID {
+			roots = append(roots, conv)
+		} else {
+			replies[conv] = append(replies[conv], tweets[i].ID)
+		}
+	}
+
+	if len(roots) > 0 {
+		if _, err := gorm.G[Tweet](tx).Where("id IN ?", roots).Update(ctx, "conversation_id", gorm.Expr("id")); err != nil {
+			return err
+		}
+	}
+	for conv, ids := range replies {
+		if _, err := gorm.G[Tweet](tx).Where("id IN ?", ids).Update(ctx, "conversation_id", conv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *tweetRepo) GetTweet(ctx context.Context, tweetID int64) (*Tweet, error) {
-	tweet, err := gorm.G[Tweet](r.db).Where("id = ?", tweetID).First(ctx)
+	tweet, err := gorm.G[Tweet](r.db).Scopes(unscoped).Where("id = ?", tweetID).First(ctx)
 	if err != nil {
 		log.Printf("could not get tweet with ID %d: %v", tweetID, err)
 		return nil, err
@@ -49,12 +234,283 @@ func (r *tweetRepo) GetTweet(ctx context.Context, tweetID int64) (*Tweet, error)
 	return &tweet, err
 }
 
-func (r *tweetRepo) GetTweetsFromUsers(ctx context.Context, userIds []int64) ([]Tweet, error) {
-	tweets, err := gorm.G[Tweet](r.db).Where("user_id IN ?", userIds).Order("created_at DESC").Limit(50).Find(ctx)
+func (r *tweetRepo) GetTweets(ctx context.Context, tweetIDs []int64) ([]Tweet, error) {
+	tweets, err := gorm.G[Tweet](r.db).Scopes(unscoped).Where("id IN ?", tweetIDs).Find(ctx)
+	if err != nil {
+		log.Printf("could not get tweets %v: %v", tweetIDs, err)
+		return nil, err
+	}
+
+	return tweets, err
+}
+
+// InsertRetweet stores a retweet unless the user has already retweeted the
+// same original, in which case the existing retweet is loaded instead.
+func (r *tweetRepo) InsertRetweet(ctx context.Context, retweet *Tweet) error {
+	inserted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := gorm.WithResult()
+		if err := gorm.G[Tweet](tx, res, clause.OnConflict{DoNothing: true}).Create(ctx, retweet); err != nil {
+			return err
+		}
+		if inserted = res.RowsAffected == 1; !inserted {
+			return nil
+		}
+		return insertOutbox(ctx, tx, []Tweet{*retweet})
+	})
+	if err != nil {
+		log.Printf("could not insert retweet of %d for userId=%d: %v", *retweet.OriginalID, retweet.UserID, err)
+		return err
+	}
+	if inserted {
+		return nil
+	}
+
+	existing, err := gorm.G[Tweet](r.db).Where("user_id = ? AND original_id = ? AND kind = ?", retweet.UserID, *retweet.OriginalID, KindRetweet).First(ctx)
+	if err != nil {
+		log.Printf("could not fetch existing retweet of %d for userId=%d: %v", *retweet.OriginalID, retweet.UserID, err)
+		return err
+	}
+
+	*retweet = existing
+	return nil
+}
+
+func (r *tweetRepo) DeleteRetweet(ctx context.Context, userId, originalID int64) error {
+	// retweets are removed for good so the same tweet can be retweeted again
+	n, err := gorm.G[Tweet](r.db).Scopes(unscoped).Where("user_id = ? AND original_id = ? AND kind = ?", userId, originalID, KindRetweet).Delete(ctx)
+	if err != nil {
+		log.Printf("could not delete retweet of %d for userId=%d: %v", originalID, userId, err)
+		return err
+	}
+	if n == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *tweetRepo) DeleteTweet(ctx context.Context, tweetID int64) error {
+	n, err := gorm.G[Tweet](r.db).Where("id = ?", tweetID).Delete(ctx)
+	if err != nil {
+		log.Printf("could not delete tweet %d: %v", tweetID, err)
+		return err
+	}
+	if n == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateText replaces the text of a tweet and keeps the previous one as a
+// revision. The update only applies if the text has not changed since the
+// tweet was read.
+func (r *tweetRepo) UpdateText(ctx context.Context, tweet *Tweet, text string, mentions []Mention, editedAt time.Time) error {
+	revision := &Revision{
+		TweetID: tweet.ID,
+		Text: tweet.Text,
+		CreatedAt: tweet.CreatedAt,
+		ReplacedAt: editedAt,
+	}
+	if tweet.EditedAt != nil {
+		revision.CreatedAt = *tweet.EditedAt
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[Revision](tx).Create(ctx, revision); err != nil {
+			return err
+		}
+
+		n, err := gorm.G[Tweet](tx).Where("id = ? AND text = ?", tweet.ID, tweet.Text).Updates(ctx, Tweet{
+			Text: text,
+			EditedAt: &editedAt,
+			RevisionCount: tweet.RevisionCount + 1,
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if _, err := gorm.G[TweetHashtag](tx).Where("tweet_id = ?", tweet.ID).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err := gorm.G[Mention](tx).Where("tweet_id = ?", tweet.ID).Delete(ctx); err != nil {
+			return err
+		}
+
+		edited := []Tweet{{ID: tweet.ID, Text: text, Mentions: mentions}}
+		if err := insertHashtags(ctx, tx, edited); err != nil {
+			return err
+		}
+		return insertMentions(ctx, tx, edited)
+	})
+	if err != nil {
+		log.Printf("could not update text of tweet %d: %v", tweet.ID, err)
+		return err
+	}
+
+	tweet.Text = text
+	tweet.Mentions = mentions
+	tweet.EditedAt = &editedAt
+	tweet.RevisionCount++
+	return nil
+}
+
+func (r *tweetRepo) GetRevisions(ctx context.Context, tweetID int64) ([]Revision, error) {
+	revisions, err := gorm.G[Revision](r.db).Where("tweet_id = ?", tweetID).Order("replaced_at ASC, id ASC").Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch revisions of tweet %d: %v", tweetID, err)
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetTweetsFromUsers is a keyset query on idx_user_tweets, so a page costs
+// the same however far down the feed it is.
+func (r *tweetRepo) GetTweetsFromUsers(ctx context.Context, userIds []int64, page Page) ([]Tweet, error) {
+	q := gorm.G[Tweet](r.db).Where("user_id IN ?", userIds)
+	if page.MaxID > 0 {
+		q = q.Where("id < ?", page.MaxID)
+	}
+	if page.SinceID > 0 {
+		q = q.Where("id > ?", page.SinceID)
+	}
+
+	order := "id DESC"
+	if page.Forward() {
+		order = "id ASC"
+	}
+
+	tweets, err := q.Order(order).Limit(page.Count).Find(ctx)
 	if err != nil {
 		log.Printf("could not fetch tweets from users: %v", err)
 		return nil, err
 	}
 
+	if page.Forward() {
+		slices.Reverse(tweets)
+	}
+	return tweets, nil
+}
+
+func (r *tweetRepo) GetTweetsByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error) {
+	q := gorm.G[Tweet](r.db).Where("user_id = ?", userId)
+	if !filter.Replies {
+		q = q.Where("in_reply_to_id IS NULL")
+	}
+	if !filter.Retweets {
+		q = q.Where("kind <> ?", KindRetweet)
+	}
+	if page.MaxID > 0 {
+		q = q.Where("id < ?", page.MaxID)
+	}
+	if page.SinceID > 0 {
+		q = q.Where("id > ?", page.SinceID)
+	}
+
+	order := "id DESC"
+	if page.Forward() {
+		order = "id ASC"
+	}
+
+	tweets, err := q.Order(order).Limit(page.Count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch tweets of userId=%d: %v", userId, err)
+		return nil, err
+	}
+
+	if page.Forward() {
+		slices.Reverse(tweets)
+	}
+	return tweets, nil
+}
+
+func (r *tweetRepo) GetConversation(ctx context.Context, conversationID int64) ([]Tweet, error) {
+	tweets, err := gorm.G[Tweet](r.db).Scopes(unscoped).Where("conversation_id = ?", conversationID).Order("id ASC").Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch conversation %d: %v", conversationID, err)
+		return nil, err
+	}
+
 	return tweets, err
-}
\ No newline at end of file
+}
+
+// GetTweetsByHashtag returns the newest tweets tagged with tag, starting
+// below maxID unless it is 0.
+func (r *tweetRepo) GetTweetsByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error) {
+	q := gorm.G[Tweet](r.db).Where("id IN (SELECT tweet_id FROM tweet_hashtags WHERE tag = ?)", tag)
+	if maxID > 0 {
+		q = q.Where("id < ?", maxID)
+	}
+
+	tweets, err := q.Order("id DESC").Limit(count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch tweets for hashtag %s: %v", tag, err)
+		return nil, err
+	}
+
+	return tweets, nil
+}
+
+func (r *tweetRepo) GetMentions(ctx context.Context, tweetIDs []int64) ([]Mention, error) {
+	mentions, err := gorm.G[Mention](r.db).Where("tweet_id IN ?", tweetIDs).Order("tweet_id, start").Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch mentions of tweets %v: %v", tweetIDs, err)
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+func (r *tweetRepo) GetAttachments(ctx context.Context, tweetIDs []int64) ([]TweetMedia, error) {
+	attachments, err := gorm.G[TweetMedia](r.db).Where("tweet_id IN ?", tweetIDs).Order("tweet_id, position").Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch media of tweets %v: %v", tweetIDs, err)
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+// GetTweetsMentioning returns the newest tweets mentioning userId, starting
+// below maxID unless it is 0.
+func (r *tweetRepo) GetTweetsMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error) {
+	q := gorm.G[Tweet](r.db).Where("id IN (SELECT tweet_id FROM mentions WHERE user_id = ?)", userId)
+	if maxID > 0 {
+		q = q.Where("id < ?", maxID)
+	}
+
+	tweets, err := q.Order("id DESC").Limit(count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch tweets mentioning userId=%d: %v", userId, err)
+		return nil, err
+	}
+
+	return tweets, nil
+}
+
+func (r *tweetRepo) GetOutbox(ctx context.Context, count int) ([]int64, error) {
+	entries, err := gorm.G[OutboxEntry](r.db).Order("tweet_id ASC").Limit(count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch the outbox: %v", err)
+		return nil, err
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.TweetID
+	}
+	return ids, nil
+}
+
+func (r *tweetRepo) DeleteOutbox(ctx context.Context, tweetIDs []int64) error {
+	if _, err := gorm.G[OutboxEntry](r.db).Where("tweet_id IN ?", tweetIDs).Delete(ctx); err != nil {
+		log.Printf("could not delete %d tweets from the outbox: %v", len(tweetIDs), err)
+		return err
+	}
+	return nil
+}
@@ -3,37 +3,819 @@ package tweet
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/media"
+	"github.com/daniiltsioma/twitter/internal/snowflake"
+	"github.com/daniiltsioma/twitter/internal/user"
+	"gorm.io/gorm"
 )
 
 const MaxTweetLength = 280
 
+// MaxThreadLength is how many tweets can be posted as one thread.
+const MaxThreadLength = 25
+
+// DefaultEditWindow is how long after posting the author may edit a tweet.
+const DefaultEditWindow = 30 * time.Minute
+
 var (
 	ErrTextTooLong = errors.New("text too long")
+	ErrEmptyText = errors.New("text cannot be empty")
+	ErrTweetNotFound = errors.New("tweet not found")
+	ErrNotRetweeted = errors.New("tweet is not retweeted")
+	ErrTweetDeleted = errors.New("tweet was deleted")
+	ErrNotAuthor = errors.New("only the author can change this tweet")
+	ErrNotEditable = errors.New("retweets cannot be edited")
+	ErrEditWindowClosed = errors.New("edit window has closed")
+	ErrEditConflict = errors.New("tweet was edited concurrently")
+	ErrTooManyMedia = errors.New("too many media attached")
+	ErrMediaNotOwned = errors.New("media must exist and be uploaded by the author")
+	ErrEmptyThread = errors.New("thread needs at least one tweet")
+	ErrThreadTooLong = errors.New("thread has too many tweets")
+	ErrUserNotFound = errors.New("user not found")
 )
 
+// ThreadTextError tells which tweet of a thread failed validation.
+type ThreadTextError struct {
+	Index int
+	Err error
+}
+
+func (e *ThreadTextError) Error() string {
+	return fmt.Sprintf("tweet %d: %v", e.Index, e.Err)
+}
+
+func (e *ThreadTextError) Unwrap() error {
+	return e.Err
+}
+
 type TweetService interface {
 	Post(ctx context.Context, tweets []Tweet) error
+	// PostThread stores texts as a chain of replies by userId, all of them
+	// or none, and returns the tweets in order.
+	PostThread(ctx context.Context, userId int64, texts []string) ([]Tweet, error)
 	Get(ctx context.Context, tweetID int64) (*Tweet, error)
+	// Exists fails the way Get does when tweetID cannot be read, without
+	// loading anything else about the tweet.
+	Exists(ctx context.Context, tweetID int64) error
 
-	GetFromUsers(ctx context.Context, userIds []int64) ([]Tweet, error)
+	GetFromUsers(ctx context.Context, userIds []int64, page Page) ([]Tweet, error)
+	// GetByIDs returns the tweets in the order of tweetIDs, leaving out
+	// unknown and deleted ones.
+	GetByIDs(ctx context.Context, tweetIDs []int64) ([]Tweet, error)
+	// GetByUser and GetByUsername return a page of a user's profile.
+	GetByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error)
+	GetByUsername(ctx context.Context, username string, filter ProfileFilter, page Page) ([]Tweet, error)
+	GetThread(ctx context.Context, tweetID int64) (*Thread, error)
+	GetByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error)
+	GetMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error)
+
+	// ResolveMentions looks up the users mentioned in text so tweets can be
+	// queued with their mentions already resolved.
+	ResolveMentions(ctx context.Context, text string) []Mention
+	// CheckMedia validates the media a user wants to attach to a new tweet
+	// and returns their IDs without duplicates.
+	CheckMedia(ctx context.Context, userId int64, mediaIDs []int64) ([]int64, error)
+
+	Retweet(ctx context.Context, userId, tweetID int64) (*Tweet, error)
+	Unretweet(ctx context.Context, userId, tweetID int64) error
+
+	Delete(ctx context.Context, userId, tweetID int64) error
+
+	Edit(ctx context.Context, userId, tweetID int64, text string) (*Tweet, error)
+	History(ctx context.Context, tweetID int64) ([]Revision, error)
+
+	// AssignID gives a new tweet its ID ahead of storing it, tweets that
+	// already have one keep it.
+	AssignID(tweet *Tweet) error
+	// Stored reports which of the tweet IDs are stored, deleted ones included.
+	Stored(ctx context.Context, tweetIDs []int64) (map[int64]bool, error)
+
+	// AddPending shows a queued tweet to its author on their own reads,
+	// marked pending, until RemovePending is called once it was flushed.
+	AddPending(tweet Tweet)
+	RemovePending(tweets []Tweet)
+	// MergePending merges the viewer's pending tweets that fall on page
+	// into tweets, newest first.
+	MergePending(ctx context.Context, tweets []Tweet, page Page) []Tweet
+}
+
+// Decorator fills in data owned by other packages, such as engagement
+// counts, on tweets before they are returned. The viewer, if any, is
+// available through auth.UserIDFromContext.
+type Decorator interface {
+	Decorate(ctx context.Context, tweets []Tweet) error
+}
+
+// Listener is told about tweets and retweets once they are stored, for
+// example to fan them out to timelines. It runs in the background, off the
+// outbox, and gets the tweets again until it returns nil.
+type Listener interface {
+	Stored(ctx context.Context, tweets []Tweet) error
+}
+
+type Option func(*tweetService)
+
+func WithDecorator(d Decorator) Option {
+	return func(s *tweetService) {
+		s.decorators = append(s.decorators, d)
+	}
+}
+
+func WithListener(l Listener) Option {
+	return func(s *tweetService) {
+		s.listeners = append(s.listeners, l)
+	}
+}
+
+func WithUserLookup(users UserLookup) Option {
+	return func(s *tweetService) {
+		s.users = users
+	}
+}
+
+func WithProfileLookup(profiles ProfileLookup) Option {
+	return func(s *tweetService) {
+		s.profiles = profiles
+	}
+}
+
+func WithMedia(media MediaLookup) Option {
+	return func(s *tweetService) {
+		s.media = media
+	}
+}
+
+// IDGenerator hands out tweet IDs, snowflake.Generator satisfies it.
+type IDGenerator interface {
+	Next() (int64, error)
+}
+
+func WithIDs(ids IDGenerator) Option {
+	return func(s *tweetService) {
+		s.ids = ids
+	}
+}
+
+func WithEditWindow(d time.Duration) Option {
+	return func(s *tweetService) {
+		s.editWindow = d
+	}
 }
 
 type tweetService struct {
 	repo TweetRepo
+	decorators []Decorator
+	listeners []Listener
+	users UserLookup
+	profiles ProfileLookup
+	media MediaLookup
+	editWindow time.Duration
+	ids IDGenerator
+	pending *pendingSet
+	// nudge wakes up relay when tweets were stored
+	nudge chan struct{}
+	now func() time.Time
 }
 
-func NewService(ctx context.Context, repo TweetRepo) *tweetService {
-	return &tweetService{repo: repo}
+func NewService(ctx context.Context, repo TweetRepo, opts ...Option) *tweetService {
+	s := &tweetService{
+		repo: repo,
+		editWindow: DefaultEditWindow,
+		pending: newPendingSet(),
+		nudge: make(chan struct{}, 1),
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.ids == nil {
+		// worker 0 is always valid
+		s.ids, _ = snowflake.New(0)
+	}
+
+	go s.relay(ctx)
+	return s
 }
 
 func (s *tweetService) Post(ctx context.Context, tweets []Tweet) error {
-	return s.repo.InsertMany(ctx, tweets)
+	for i := range tweets {
+		if tweets[i].Kind == "" {
+			tweets[i].Kind = KindTweet
+		}
+		if err := s.AssignID(&tweets[i]); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.InsertMany(ctx, tweets); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+func (s *tweetService) PostThread(ctx context.Context, userId int64, texts []string) ([]Tweet, error) {
+	if len(texts) == 0 {
+		return nil, ErrEmptyThread
+	}
+	if len(texts) > MaxThreadLength {
+		return nil, ErrThreadTooLong
+	}
+	for i, text := range texts {
+		if err := ValidateText(text); err != nil {
+			return nil, &ThreadTextError{Index: i, Err: err}
+		}
+	}
+
+	mentions := resolveAllMentions(ctx, s.users, texts)
+
+	// IDs are taken in order, so the thread reads top to bottom
+	tweets := make([]Tweet, len(texts))
+	for i, text := range texts {
+		tweets[i] = Tweet{
+			UserID: userId,
+			Text: text,
+			Kind: KindTweet,
+			Mentions: mentions[i],
+		}
+		if err := s.AssignID(&tweets[i]); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			tweets[i].InReplyToID = &tweets[i-1].ID
+		}
+	}
+
+	if err := s.repo.InsertMany(ctx, tweets); err != nil {
+		return nil, err
+	}
+
+	s.notify()
+	return tweets, nil
 }
 
 func (s *tweetService) Get(ctx context.Context, tweetID int64) (*Tweet, error) {
-	return s.repo.GetTweet(ctx, tweetID)
+	tweet, err := s.lookup(ctx, tweetID)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets := []Tweet{*tweet}
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	return &tweets[0], nil
+}
+
+func (s *tweetService) Exists(ctx context.Context, tweetID int64) error {
+	_, err := s.lookup(ctx, tweetID)
+	return err
+}
+
+// lookup loads the row of a tweet the viewer may read, falling back to the
+// viewer's pending tweets.
+func (s *tweetService) lookup(ctx context.Context, tweetID int64) (*Tweet, error) {
+	tweet, err := s.repo.GetTweet(ctx, tweetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if viewer, ok := auth.UserIDFromContext(ctx); ok {
+			if pending, ok := s.pending.get(viewer, tweetID); ok {
+				tweet, err = &pending, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tweet.DeletedAt.Valid {
+		return nil, ErrTweetDeleted
+	}
+	return tweet, nil
+}
+
+func (s *tweetService) GetFromUsers(ctx context.Context, userIds []int64, page Page) ([]Tweet, error) {
+	if len(userIds) == 0 {
+		return []Tweet{}, nil
+	}
+
+	tweets, err := s.repo.GetTweetsFromUsers(ctx, userIds, page)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	if viewer, ok := auth.UserIDFromContext(ctx); ok && slices.Contains(userIds, viewer) {
+		tweets = s.MergePending(ctx, tweets, page)
+	}
+	return tweets, nil
 }
 
-func (s *tweetService) GetFromUsers(ctx context.Context, userIds []int64) ([]Tweet, error) {
-	return s.repo.GetTweetsFromUsers(ctx, userIds)	
-}
\ No newline at end of file
+func (s *tweetService) GetByIDs(ctx context.Context, tweetIDs []int64) ([]Tweet, error) {
+	if len(tweetIDs) == 0 {
+		return []Tweet{}, nil
+	}
+
+	found, err := s.repo.GetTweets(ctx, tweetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]Tweet, len(found))
+	for _, t := range found {
+		byID[t.ID] = t
+	}
+	tweets := make([]Tweet, 0, len(found))
+	for _, id := range tweetIDs {
+		if t, ok := byID[id]; ok && !t.DeletedAt.Valid {
+			tweets = append(tweets, t)
+		}
+	}
+
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	return tweets, nil
+}
+
+func (s *tweetService) GetByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error) {
+	if s.profiles != nil {
+		users, err := s.profiles.GetByIDs(ctx, []int64{userId})
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, ErrUserNotFound
+		}
+	}
+
+	tweets, err := s.repo.GetTweetsByUser(ctx, userId, filter, page)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	if viewer, ok := auth.UserIDFromContext(ctx); ok && viewer == userId {
+		tweets = s.mergePending(ctx, tweets, page, filter.Allows)
+	}
+	return tweets, nil
+}
+
+func (s *tweetService) GetByUsername(ctx context.Context, username string, filter ProfileFilter, page Page) ([]Tweet, error) {
+	if s.profiles == nil {
+		return nil, ErrUserNotFound
+	}
+
+	u, err := s.profiles.GetByUsername(ctx, username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByUser(ctx, u.ID, filter, page)
+}
+
+func (s *tweetService) GetByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error) {
+	tweets, err := s.repo.GetTweetsByHashtag(ctx, NormalizeHashtag(tag), maxID, count)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	return tweets, nil
+}
+
+func (s *tweetService) GetMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error) {
+	tweets, err := s.repo.GetTweetsMentioning(ctx, userId, maxID, count)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	return tweets, nil
+}
+
+func (s *tweetService) ResolveMentions(ctx context.Context, text string) []Mention {
+	return resolveMentions(ctx, s.users, text)
+}
+
+func (s *tweetService) CheckMedia(ctx context.Context, userId int64, mediaIDs []int64) ([]int64, error) {
+	return checkMedia(ctx, s.media, userId, mediaIDs)
+}
+
+func (s *tweetService) Retweet(ctx context.Context, userId, tweetID int64) (*Tweet, error) {
+	original, err := s.repo.GetTweet(ctx, tweetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTweetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if original.DeletedAt.Valid {
+		return nil, ErrTweetDeleted
+	}
+
+	// retweeting a retweet reshares the tweet underneath it
+	if original.Kind == KindRetweet && original.OriginalID != nil {
+		return s.Retweet(ctx, userId, *original.OriginalID)
+	}
+
+	retweet := &Tweet{
+		UserID: userId,
+		Kind: KindRetweet,
+		OriginalID: &original.ID,
+	}
+	if err := s.AssignID(retweet); err != nil {
+		return nil, err
+	}
+	if err := s.repo.InsertRetweet(ctx, retweet); err != nil {
+		return nil, err
+	}
+	s.notify()
+
+	retweet.Original = original
+	return retweet, nil
+}
+
+func (s *tweetService) Unretweet(ctx context.Context, userId, tweetID int64) error {
+	err := s.repo.DeleteRetweet(ctx, userId, tweetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotRetweeted
+	}
+	return err
+}
+
+// Delete soft-deletes a tweet of userId. Retweets are undone instead, the
+// same as through Unretweet.
+func (s *tweetService) Delete(ctx context.Context, userId, tweetID int64) error {
+	tweet, err := s.repo.GetTweet(ctx, tweetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTweetNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if tweet.DeletedAt.Valid {
+		return ErrTweetDeleted
+	}
+	if tweet.UserID != userId {
+		return ErrNotAuthor
+	}
+
+	if tweet.Kind == KindRetweet && tweet.OriginalID != nil {
+		return s.repo.DeleteRetweet(ctx, userId, *tweet.OriginalID)
+	}
+	return s.repo.DeleteTweet(ctx, tweetID)
+}
+
+func (s *tweetService) Edit(ctx context.Context, userId, tweetID int64, text string) (*Tweet, error) {
+	if err := ValidateText(text); err != nil {
+		return nil, err
+	}
+
+	tweet, err := s.repo.GetTweet(ctx, tweetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTweetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tweet.DeletedAt.Valid {
+		return nil, ErrTweetDeleted
+	}
+	if tweet.UserID != userId {
+		return nil, ErrNotAuthor
+	}
+	if tweet.Kind == KindRetweet {
+		return nil, ErrNotEditable
+	}
+
+	now := s.now()
+	if now.Sub(tweet.CreatedAt) > s.editWindow {
+		return nil, ErrEditWindowClosed
+	}
+
+	if tweet.Text != text {
+		mentions := resolveMentions(ctx, s.users, text)
+		err = s.repo.UpdateText(ctx, tweet, text, mentions, now)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEditConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tweets := []Tweet{*tweet}
+	if err := s.hydrate(ctx, tweets); err != nil {
+		return nil, err
+	}
+	return &tweets[0], nil
+}
+
+func (s *tweetService) History(ctx context.Context, tweetID int64) ([]Revision, error) {
+	if _, err := s.Get(ctx, tweetID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetRevisions(ctx, tweetID)
+}
+
+// ValidateText checks the text of a new or edited tweet, counting its
+// length with WeightedLength.
+func ValidateText(text string) error {
+	if text == "" {
+		return ErrEmptyText
+	}
+	if n := WeightedLength(text); n > MaxTweetLength {
+		return &TextTooLongError{Length: n}
+	}
+	return nil
+}
+
+// hydrate inlines the originals of retweets and quote tweets and runs the
+// decorators over both.
+func (s *tweetService) hydrate(ctx context.Context, tweets []Tweet) error {
+	if err := s.decorate(ctx, tweets); err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for _, t := range tweets {
+		if t.OriginalID != nil && t.Original == nil {
+			ids = append(ids, *t.OriginalID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	originals, err := s.repo.GetTweets(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if err := s.decorate(ctx, originals); err != nil {
+		return err
+	}
+
+	byID := make(map[int64]*Tweet, len(originals))
+	for i := range originals {
+		byID[originals[i].ID] = &originals[i]
+	}
+	for i := range tweets {
+		if tweets[i].OriginalID == nil {
+			continue
+		}
+		original, ok := byID[*tweets[i].OriginalID]
+		if !ok {
+			original = &Tweet{ID: *tweets[i].OriginalID, DeletedAt: gorm.DeletedAt{Valid: true}}
+		}
+		if original.DeletedAt.Valid {
+			t := tombstone(*original)
+			original = &t
+		}
+		tweets[i].Original = original
+	}
+	return nil
+}
+
+func (s *tweetService) GetThread(ctx context.Context, tweetID int64) (*Thread, error) {
+	tweet, err := s.repo.GetTweet(ctx, tweetID)
+	if err != nil {
+		return nil, err
+	}
+
+	conversationID := tweet.ConversationID
+	if conversationID == 0 {
+		conversationID = tweet.ID
+	}
+
+	conversation, err := s.repo.GetConversation(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hydrate(ctx, conversation); err != nil {
+		return nil, err
+	}
+	// deleted tweets stay in the tree so their replies keep their place
+	for i := range conversation {
+		if conversation[i].DeletedAt.Valid {
+			conversation[i] = tombstone(conversation[i])
+		}
+	}
+
+	focus := []Tweet{*tweet}
+	found := false
+	for _, t := range conversation {
+		if t.ID == tweet.ID {
+			focus[0], found = t, true
+		}
+	}
+	if !found {
+		if err := s.hydrate(ctx, focus); err != nil {
+			return nil, err
+		}
+		if focus[0].DeletedAt.Valid {
+			focus[0] = tombstone(focus[0])
+		}
+	}
+
+	return buildThread(focus[0], conversation), nil
+}
+
+// buildThread arranges the tweets of a conversation around the focused
+// tweet. Conversation tweets are expected in chronological order, which is
+// the order replies end up in on every level of the tree.
+func buildThread(focus Tweet, conversation []Tweet) *Thread {
+	byID := make(map[int64]Tweet, len(conversation))
+	children := map[int64][]Tweet{}
+	for _, t := range conversation {
+		byID[t.ID] = t
+		if t.InReplyToID != nil {
+			children[*t.InReplyToID] = append(children[*t.InReplyToID], t)
+		}
+	}
+
+	thread := &Thread{
+		Ancestors: []Tweet{},
+		Tweet: focus,
+	}
+
+	seen := map[int64]bool{focus.ID: true}
+	for parentID := focus.InReplyToID; parentID != nil; {
+		parent, ok := byID[*parentID]
+		if !ok || seen[parent.ID] {
+			break
+		}
+		seen[parent.ID] = true
+		thread.Ancestors = append([]Tweet{parent}, thread.Ancestors...)
+		parentID = parent.InReplyToID
+	}
+
+	var replies func(id int64) []*ThreadNode
+	replies = func(id int64) []*ThreadNode {
+		nodes := []*ThreadNode{}
+		for _, c := range children[id] {
+			if seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+			nodes = append(nodes, &ThreadNode{Tweet: c, Replies: replies(c.ID)})
+		}
+		return nodes
+	}
+	thread.Replies = replies(focus.ID)
+
+	return thread
+}
+
+// decorate attaches mentions and runs the decorators.
+func (s *tweetService) decorate(ctx context.Context, tweets []Tweet) error {
+	if len(tweets) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(tweets))
+	for _, t := range tweets {
+		ids = append(ids, t.ID)
+	}
+	mentions, err := s.repo.GetMentions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	byTweet := map[int64][]Mention{}
+	for _, m := range mentions {
+		byTweet[m.TweetID] = append(byTweet[m.TweetID], m)
+	}
+	for i := range tweets {
+		// pending tweets carry what they were queued with
+		if !tweets[i].Pending {
+			tweets[i].Mentions = byTweet[tweets[i].ID]
+		}
+	}
+
+	if err := s.attachMedia(ctx, ids, tweets); err != nil {
+		return err
+	}
+
+	for _, d := range s.decorators {
+		if err := d.Decorate(ctx, tweets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *tweetService) attachMedia(ctx context.Context, ids []int64, tweets []Tweet) error {
+	attachments, err := s.repo.GetAttachments(ctx, ids)
+	if err != nil || len(attachments) == 0 {
+		return err
+	}
+
+	byTweet := map[int64][]int64{}
+	mediaIDs := []int64{}
+	for _, a := range attachments {
+		byTweet[a.TweetID] = append(byTweet[a.TweetID], a.MediaID)
+		mediaIDs = append(mediaIDs, a.MediaID)
+	}
+
+	byID := map[int64]media.Media{}
+	if s.media != nil {
+		found, err := s.media.GetMany(ctx, mediaIDs)
+		if err != nil {
+			return err
+		}
+		for _, m := range found {
+			byID[m.ID] = m
+		}
+	}
+
+	for i := range tweets {
+		if tweets[i].Pending {
+			continue
+		}
+		tweets[i].MediaIDs = byTweet[tweets[i].ID]
+		tweets[i].Media = nil
+		for _, id := range tweets[i].MediaIDs {
+			if m, ok := byID[id]; ok {
+				tweets[i].Media = append(tweets[i].Media, m)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *tweetService) AssignID(tweet *Tweet) error {
+	if tweet.ID != 0 {
+		return nil
+	}
+
+	id, err := s.ids.Next()
+	if err != nil {
+		return err
+	}
+	tweet.ID = id
+	tweet.CreatedAt = snowflake.Time(id)
+	return nil
+}
+
+func (s *tweetService) Stored(ctx context.Context, tweetIDs []int64) (map[int64]bool, error) {
+	stored := make(map[int64]bool, len(tweetIDs))
+	if len(tweetIDs) == 0 {
+		return stored, nil
+	}
+
+	tweets, err := s.repo.GetTweets(ctx, tweetIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tweets {
+		stored[t.ID] = true
+	}
+	return stored, nil
+}
+
+func (s *tweetService) AddPending(tweet Tweet) {
+	s.pending.add(tweet)
+}
+
+func (s *tweetService) RemovePending(tweets []Tweet) {
+	s.pending.remove(tweets)
+}
+
+func (s *tweetService) MergePending(ctx context.Context, tweets []Tweet, page Page) []Tweet {
+	return s.mergePending(ctx, tweets, page, nil)
+}
+
+// mergePending is MergePending for the pending tweets that keep, if set,
+// allows.
+func (s *tweetService) mergePending(ctx context.Context, tweets []Tweet, page Page, keep func(Tweet) bool) []Tweet {
+	viewer, ok := auth.UserIDFromContext(ctx)
+	if !ok {
+		return tweets
+	}
+
+	pending := slices.DeleteFunc(s.pending.list(viewer), func(t Tweet) bool {
+		return !page.Contains(t.ID) || (keep != nil && !keep(t))
+	})
+	if len(pending) == 0 {
+		return tweets
+	}
+	if err := s.hydrate(ctx, pending); err != nil {
+		log.Printf("could not hydrate pending tweets of userId=%d: %v", viewer, err)
+	}
+	return page.Trim(mergePending(tweets, pending))
+}
@@ -1,16 +1,27 @@
 package tweet
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/media"
 	"gorm.io/gorm"
 )
 
 type mockRepo struct {
 	tweets map[int64]Tweet
+	revisions []Revision
+
+	// outbox is read by relay in the background
+	outboxMu sync.Mutex
+	outbox []int64
 }
 
 func NewMockRepo() *mockRepo {
@@ -20,7 +31,7 @@ func NewMockRepo() *mockRepo {
 }
 
 func (r *mockRepo) InsertTweet(ctx context.Context, tweet *Tweet) error {
-	tweet.ID = int64(len(r.tweets))
+	tweet.ID = int64(len(r.tweets)) + 1
 	r.tweets[tweet.ID] = *tweet
 	return nil
 }
@@ -28,10 +39,32 @@ func (r *mockRepo) InsertTweet(ctx context.Context, tweet *Tweet) error {
 func (r *mockRepo) InsertMany(ctx context.Context, tweets []Tweet) error {
 	for _, tweet := range tweets {
 		r.tweets[tweet.ID] = tweet
+		r.addOutbox(tweet.ID)
 	}
 	return nil
 }
 
+func (r *mockRepo) addOutbox(tweetID int64) {
+	r.outboxMu.Lock()
+	defer r.outboxMu.Unlock()
+	r.outbox = append(r.outbox, tweetID)
+}
+
+func (r *mockRepo) GetOutbox(ctx context.Context, count int) ([]int64, error) {
+	r.outboxMu.Lock()
+	defer r.outboxMu.Unlock()
+	return slices.Clone(r.outbox[:min(count, len(r.outbox))]), nil
+}
+
+func (r *mockRepo) DeleteOutbox(ctx context.Context, tweetIDs []int64) error {
+	r.outboxMu.Lock()
+	defer r.outboxMu.Unlock()
+	r.outbox = slices.DeleteFunc(r.outbox, func(id int64) bool {
+		return slices.Contains(tweetIDs, id)
+	})
+	return nil
+}
+
 func (r *mockRepo) GetTweet(ctx context.Context, tweetID int64) (*Tweet, error) {
 	tweet, ok := r.tweets[tweetID]
 	if !ok {
@@ -40,10 +73,121 @@ func (r *mockRepo) GetTweet(ctx context.Context, tweetID int64) (*Tweet, error)
 	return &tweet, nil
 }
 
-func (r *mockRepo) GetTweetsFromUsers(ctx context.Context, userIds []int64) ([]Tweet, error) {
+func (r *mockRepo) GetTweets(ctx context.Context, tweetIDs []int64) ([]Tweet, error) {
+	tweets := []Tweet{}
+	for _, id := range tweetIDs {
+		if tweet, ok := r.tweets[id]; ok {
+			tweets = append(tweets, tweet)
+		}
+	}
+	return tweets, nil
+}
+
+func (r *mockRepo) InsertRetweet(ctx context.Context, retweet *Tweet) error {
+	for _, tweet := range r.tweets {
+		if tweet.Kind == KindRetweet && tweet.UserID == retweet.UserID && *tweet.OriginalID == *retweet.OriginalID {
+			*retweet = tweet
+			return nil
+		}
+	}
+	if err := r.InsertTweet(ctx, retweet); err != nil {
+		return err
+	}
+	r.addOutbox(retweet.ID)
+	return nil
+}
+
+func (r *mockRepo) DeleteRetweet(ctx context.Context, userId, originalID int64) error {
+	for id, tweet := range r.tweets {
+		if tweet.Kind == KindRetweet && tweet.UserID == userId && *tweet.OriginalID == originalID {
+			delete(r.tweets, id)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *mockRepo) DeleteTweet(ctx context.Context, tweetID int64) error {
+	tweet, ok := r.tweets[tweetID]
+	if !ok || tweet.DeletedAt.Valid {
+		return gorm.ErrRecordNotFound
+	}
+	tweet.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.tweets[tweetID] = tweet
+	return nil
+}
+
+func (r *mockRepo) UpdateText(ctx context.Context, tweet *Tweet, text string, mentions []Mention, editedAt time.Time) error {
+	r.revisions = append(r.revisions, Revision{TweetID: tweet.ID, Text: tweet.Text, ReplacedAt: editedAt})
+	tweet.Text = text
+	tweet.EditedAt = &editedAt
+	tweet.RevisionCount++
+	r.tweets[tweet.ID] = *tweet
+	return nil
+}
+
+func (r *mockRepo) GetRevisions(ctx context.Context, tweetID int64) ([]Revision, error) {
+	revisions := []Revision{}
+	for _, rev := range r.revisions {
+		if rev.TweetID == tweetID {
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+func (r *mockRepo) GetTweetsByHashtag(ctx context.Context, tag string, maxID int64, count int) ([]Tweet, error) {
 	return nil, nil
 }
 
+func (r *mockRepo) GetMentions(ctx context.Context, tweetIDs []int64) ([]Mention, error) {
+	return nil, nil
+}
+
+func (r *mockRepo) GetTweetsMentioning(ctx context.Context, userId int64, maxID int64, count int) ([]Tweet, error) {
+	return nil, nil
+}
+
+func (r *mockRepo) GetAttachments(ctx context.Context, tweetIDs []int64) ([]TweetMedia, error) {
+	return nil, nil
+}
+
+func (r *mockRepo) GetTweetsFromUsers(ctx context.Context, userIds []int64, page Page) ([]Tweet, error) {
+	tweets := []Tweet{}
+	for _, tweet := range r.tweets {
+		if slices.Contains(userIds, tweet.UserID) && page.Contains(tweet.ID) {
+			tweets = append(tweets, tweet)
+		}
+	}
+	slices.SortFunc(tweets, func(a, b Tweet) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return page.Trim(tweets), nil
+}
+
+func (r *mockRepo) GetTweetsByUser(ctx context.Context, userId int64, filter ProfileFilter, page Page) ([]Tweet, error) {
+	tweets := []Tweet{}
+	for _, tweet := range r.tweets {
+		if tweet.UserID == userId && filter.Allows(tweet) && page.Contains(tweet.ID) {
+			tweets = append(tweets, tweet)
+		}
+	}
+	slices.SortFunc(tweets, func(a, b Tweet) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return page.Trim(tweets), nil
+}
+
+func (r *mockRepo) GetConversation(ctx context.Context, conversationID int64) ([]Tweet, error) {
+	tweets := []Tweet{}
+	for id := int64(0); id <= int64(len(r.tweets)); id++ {
+		if tweet, ok := r.tweets[id]; ok && tweet.ConversationID == conversationID {
+			tweets = append(tweets, tweet)
+		}
+	}
+	return tweets, nil
+}
+
 func TestServiceGetTweet(t *testing.T) {
 	repo := &mockRepo{
 		tweets: map[int64]Tweet{
@@ -69,4 +213,524 @@ func TestServiceGetTweet(t *testing.T) {
 			t.Errorf("expected error %v got %v", tt.expectedError, err)
 		}
 	}
+}
+
+type mockMediaLookup struct {
+	media []media.Media
+}
+
+func (l *mockMediaLookup) GetMany(ctx context.Context, mediaIDs []int64) ([]media.Media, error) {
+	found := []media.Media{}
+	for _, m := range l.media {
+		if slices.Contains(mediaIDs, m.ID) {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+func TestServiceCheckMedia(t *testing.T) {
+	lookup := &mockMediaLookup{media: []media.Media{
+		{ID: 1, UserID: 1},
+		{ID: 2, UserID: 1},
+		{ID: 3, UserID: 2},
+	}}
+	srv := NewService(context.Background(), NewMockRepo(), WithMedia(lookup))
+
+	tests := []struct{
+		name string
+		mediaIDs []int64
+		expectedIDs []int64
+		expectedError error
+	}{
+		{"own media", []int64{2, 1}, []int64{2, 1}, nil},
+		{"duplicates", []int64{1, 1}, []int64{1}, nil},
+		{"media of another user", []int64{1, 3}, nil, ErrMediaNotOwned},
+		{"media that does not exist", []int64{4}, nil, ErrMediaNotOwned},
+		{"too many media", []int64{1, 2, 3, 4, 5}, nil, ErrTooManyMedia},
+	}
+
+	for _, tt := range tests {
+		ids, err := srv.CheckMedia(context.Background(), 1, tt.mediaIDs)
+		if !errors.Is(err, tt.expectedError) {
+			t.Errorf("%s: expected error %v got %v", tt.name, tt.expectedError, err)
+		}
+		if !slices.Equal(ids, tt.expectedIDs) {
+			t.Errorf("%s: expected IDs %v got %v", tt.name, tt.expectedIDs, ids)
+		}
+	}
+
+	// without uploads there is nothing to own
+	srv = NewService(context.Background(), NewMockRepo())
+	if _, err := srv.CheckMedia(context.Background(), 1, []int64{1}); !errors.Is(err, ErrMediaNotOwned) {
+		t.Errorf("expected %v without media, got %v", ErrMediaNotOwned, err)
+	}
+}
+
+func TestServicePostThread(t *testing.T) {
+	repo := NewMockRepo()
+	srv := NewService(context.Background(), repo)
+	ctx := context.Background()
+
+	tweets, err := srv.PostThread(ctx, 1, []string{"1/3", "2/3", "3/3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, tweet := range tweets {
+		if _, ok := repo.tweets[tweet.ID]; !ok {
+			t.Errorf("expected tweet %d to be stored", i)
+		}
+		if i == 0 {
+			if tweet.InReplyToID != nil {
+				t.Errorf("expected the first tweet to start the thread")
+			}
+			continue
+		}
+		if tweet.ID <= tweets[i-1].ID {
+			t.Errorf("expected IDs in thread order")
+		}
+		if tweet.InReplyToID == nil || *tweet.InReplyToID != tweets[i-1].ID {
+			t.Errorf("expected tweet %d to reply to the one before it", i)
+		}
+	}
+
+	tests := []struct{
+		name string
+		texts []string
+		expectedError error
+	}{
+		{"empty thread", nil, ErrEmptyThread},
+		{"too many tweets", make([]string, MaxThreadLength+1), ErrThreadTooLong},
+		{"empty text", []string{"1/2", ""}, ErrEmptyText},
+		{"text too long", []string{strings.Repeat("a", MaxTweetLength+1), "2/2"}, ErrTextTooLong},
+	}
+
+	for _, tt := range tests {
+		stored := len(repo.tweets)
+		_, err := srv.PostThread(ctx, 1, tt.texts)
+		if !errors.Is(err, tt.expectedError) {
+			t.Errorf("%s: expected error %v got %v", tt.name, tt.expectedError, err)
+		}
+		if len(repo.tweets) != stored {
+			t.Errorf("%s: expected nothing to be stored", tt.name)
+		}
+	}
+}
+
+type recordingListener struct {
+	stored chan []Tweet
+	// fail is how many calls fail before one goes through
+	fail int
+}
+
+func (l *recordingListener) Stored(ctx context.Context, tweets []Tweet) error {
+	if l.fail > 0 {
+		l.fail--
+		return errors.New("timeline store down")
+	}
+	l.stored <- tweets
+	return nil
+}
+
+func TestServiceListener(t *testing.T) {
+	listener := &recordingListener{stored: make(chan []Tweet, 10)}
+	repo := NewMockRepo()
+	srv := NewService(context.Background(), repo, WithListener(listener))
+	ctx := context.Background()
+
+	// the listener is told in the background, each step waits for it so
+	// the repo is not written to while it is read
+	told := func() []Tweet {
+		t.Helper()
+		select {
+		case tweets := <-listener.stored:
+			return tweets
+		case <-time.After(time.Second):
+			t.Fatal("listener was not told about stored tweets")
+			return nil
+		}
+	}
+
+	if err := srv.Post(ctx, []Tweet{{UserID: 1, Text: "hello"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := told(); len(got) != 1 {
+		t.Errorf("expected the posted tweet, got %+v", got)
+	}
+
+	thread, err := srv.PostThread(ctx, 1, []string{"1/2", "2/2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := told(); len(got) != 2 {
+		t.Errorf("expected the thread, got %+v", got)
+	}
+
+	retweet, err := srv.Retweet(ctx, 2, thread[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := told(); len(got) != 1 || got[0].ID != retweet.ID || got[0].Kind != KindRetweet {
+		t.Errorf("expected the retweet, got %+v", got)
+	}
+}
+
+func TestServiceRelayRetries(t *testing.T) {
+	repo := NewMockRepo()
+	repo.tweets[1] = Tweet{ID: 1, UserID: 1, Text: "stored before a crash"}
+	repo.outbox = []int64{1}
+	listener := &recordingListener{stored: make(chan []Tweet, 10), fail: 1}
+	srv := NewService(context.Background(), repo, WithListener(listener))
+
+	// the first attempt fails, the tweet stays in the outbox
+	if n, err := srv.relayBatch(context.Background()); err == nil || n != 0 {
+		t.Fatalf("expected the failed relay to report an error, got %d, %v", n, err)
+	}
+	if ids, _ := repo.GetOutbox(context.Background(), 10); !slices.Equal(ids, []int64{1}) {
+		t.Fatalf("expected the tweet to stay in the outbox, got %v", ids)
+	}
+
+	select {
+	case tweets := <-listener.stored:
+		if len(tweets) != 1 || tweets[0].ID != 1 {
+			t.Errorf("expected the tweet to be relayed again, got %+v", tweets)
+		}
+	case <-time.After(2 * outboxInterval):
+		t.Fatal("expected the tweet to be relayed again")
+	}
+}
+
+func TestServicePendingTweets(t *testing.T) {
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, UserID: 2, Text: "stored"},
+			3: {ID: 3, UserID: 5, Text: "someone else"},
+		},
+	}
+	srv := NewService(context.Background(), repo)
+
+	srv.AddPending(Tweet{ID: 2, UserID: 2, Text: "queued"})
+	srv.AddPending(Tweet{ID: 4, UserID: 2, Text: "queued later"})
+
+	author := auth.WithUserID(context.Background(), 2)
+	other := auth.WithUserID(context.Background(), 5)
+
+	tweet, err := srv.Get(author, 2)
+	if err != nil {
+		t.Fatalf("expected the author to see their pending tweet, got %v", err)
+	}
+	if !tweet.Pending || tweet.Text != "queued" {
+		t.Errorf("expected the queued tweet marked pending, got %+v", tweet)
+	}
+	if _, err := srv.Get(other, 2); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected others not to see a pending tweet, got %v", err)
+	}
+
+	ids := func(tweets []Tweet) []int64 {
+		out := []int64{}
+		for _, t := range tweets {
+			out = append(out, t.ID)
+		}
+		return out
+	}
+
+	tweets, err := srv.GetFromUsers(author, []int64{2, 5}, Page{Count: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(tweets); !slices.Equal(got, []int64{4, 3, 2, 1}) {
+		t.Errorf("expected pending tweets merged in by ID, got %v", got)
+	}
+	tweets, _ = srv.GetFromUsers(other, []int64{2, 5}, Page{Count: 20})
+	if got := ids(tweets); !slices.Equal(got, []int64{3, 1}) {
+		t.Errorf("expected no pending tweets for others, got %v", got)
+	}
+
+	// pending tweets only show up on the page they fall on
+	tweets, _ = srv.GetFromUsers(author, []int64{2, 5}, Page{Count: 2})
+	if got := ids(tweets); !slices.Equal(got, []int64{4, 3}) {
+		t.Errorf("expected the first page, got %v", got)
+	}
+	tweets, _ = srv.GetFromUsers(author, []int64{2, 5}, Page{MaxID: 3, Count: 2})
+	if got := ids(tweets); !slices.Equal(got, []int64{2, 1}) {
+		t.Errorf("expected the second page, got %v", got)
+	}
+
+	// once flushed the stored row replaces the pending one
+	stored := Tweet{ID: 2, UserID: 2, Text: "queued"}
+	srv.Post(author, []Tweet{stored})
+	tweets, _ = srv.GetFromUsers(author, []int64{2}, Page{Count: 20})
+	if got := ids(tweets); !slices.Equal(got, []int64{4, 2, 1}) {
+		t.Errorf("expected the stored tweet once and not twice, got %v", got)
+	}
+	srv.RemovePending([]Tweet{stored})
+	tweet, err = srv.Get(author, 2)
+	if err != nil || tweet.Pending {
+		t.Errorf("expected the stored tweet after the flush, got %+v, %v", tweet, err)
+	}
+}
+
+func TestServiceGetByUser(t *testing.T) {
+	reply := int64(1)
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, UserID: 2, Text: "first"},
+			2: {ID: 2, UserID: 2, Text: "a reply", InReplyToID: &reply},
+			3: {ID: 3, UserID: 2, Kind: KindRetweet},
+			4: {ID: 4, UserID: 5, Text: "someone else"},
+		},
+	}
+	users := &mockUserLookup{users: map[string]int64{"alice": 2, "bob": 5}}
+	srv := NewService(context.Background(), repo, WithProfileLookup(users))
+
+	srv.AddPending(Tweet{ID: 5, UserID: 2, Text: "queued"})
+	srv.AddPending(Tweet{ID: 6, UserID: 2, Text: "queued reply", InReplyToID: &reply})
+
+	ids := func(tweets []Tweet) []int64 {
+		out := []int64{}
+		for _, t := range tweets {
+			out = append(out, t.ID)
+		}
+		return out
+	}
+
+	tests := []struct{
+		name string
+		ctx context.Context
+		filter ProfileFilter
+		page Page
+		expected []int64
+	}{
+		{"Defaults", context.Background(), ProfileFilter{Retweets: true}, Page{Count: 20}, []int64{3, 1}},
+		{"Replies", context.Background(), ProfileFilter{Replies: true, Retweets: true}, Page{Count: 20}, []int64{3, 2, 1}},
+		{"NoRetweets", context.Background(), ProfileFilter{}, Page{Count: 20}, []int64{1}},
+		{"Page", context.Background(), ProfileFilter{Replies: true, Retweets: true}, Page{MaxID: 3, Count: 1}, []int64{2}},
+		{"OwnPending", auth.WithUserID(context.Background(), 2), ProfileFilter{Retweets: true}, Page{Count: 20}, []int64{5, 3, 1}},
+		{"OthersPending", auth.WithUserID(context.Background(), 5), ProfileFilter{Retweets: true}, Page{Count: 20}, []int64{3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tweets, err := srv.GetByUser(tt.ctx, 2, tt.filter, tt.page)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := ids(tweets); !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	tweets, err := srv.GetByUsername(context.Background(), "alice", ProfileFilter{}, Page{Count: 20})
+	if err != nil || !slices.Equal(ids(tweets), []int64{1}) {
+		t.Errorf("expected the tweets of alice by username, got %v, %v", ids(tweets), err)
+	}
+	if _, err := srv.GetByUser(context.Background(), 9, ProfileFilter{}, Page{Count: 20}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown ID, got %v", err)
+	}
+	if _, err := srv.GetByUsername(context.Background(), "carol", ProfileFilter{}, Page{Count: 20}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown username, got %v", err)
+	}
+
+	users.err = errors.New("db down")
+	if _, err := srv.GetByUsername(context.Background(), "alice", ProfileFilter{}, Page{Count: 20}); err == nil || errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected the lookup error to be passed on, got %v", err)
+	}
+}
+
+func TestServiceGetThread(t *testing.T) {
+	reply := func(id int64) *int64 { return &id }
+
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, Text: "root", ConversationID: 1},
+			2: {ID: 2, Text: "reply to root", InReplyToID: reply(1), ConversationID: 1},
+			3: {ID: 3, Text: "reply to reply", InReplyToID: reply(2), ConversationID: 1},
+			4: {ID: 4, Text: "second reply to root", InReplyToID: reply(1), ConversationID: 1},
+			5: {ID: 5, Text: "reply to nested reply", InReplyToID: reply(3), ConversationID: 1},
+			6: {ID: 6, Text: "other conversation", ConversationID: 6},
+		},
+	}
+	srv := NewService(context.Background(), repo)
+
+	thread, err := srv.GetThread(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(thread.Ancestors) != 1 || thread.Ancestors[0].ID != 1 {
+		t.Errorf("expected root as the only ancestor, got %v", thread.Ancestors)
+	}
+	if thread.Tweet.ID != 2 {
+		t.Errorf("expected focused tweet 2, got %d", thread.Tweet.ID)
+	}
+	if len(thread.Replies) != 1 || thread.Replies[0].ID != 3 {
+		t.Fatalf("expected tweet 3 as the only reply, got %v", thread.Replies)
+	}
+	if len(thread.Replies[0].Replies) != 1 || thread.Replies[0].Replies[0].ID != 5 {
+		t.Errorf("expected tweet 5 nested under tweet 3, got %v", thread.Replies[0].Replies)
+	}
+
+	root, err := srv.GetThread(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(root.Ancestors) != 0 {
+		t.Errorf("expected no ancestors for root, got %v", root.Ancestors)
+	}
+	if len(root.Replies) != 2 || root.Replies[0].ID != 2 || root.Replies[1].ID != 4 {
+		t.Errorf("expected replies 2 and 4 in order, got %v", root.Replies)
+	}
+}
+
+func TestServiceRetweet(t *testing.T) {
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, UserID: 2, Kind: KindTweet, Text: "hello"},
+		},
+	}
+	srv := NewService(context.Background(), repo)
+	ctx := context.Background()
+
+	first, err := srv.Retweet(ctx, 3, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Kind != KindRetweet || *first.OriginalID != 1 || first.Original == nil {
+		t.Errorf("expected a retweet of tweet 1 with the original inlined, got %+v", first)
+	}
+
+	again, err := srv.Retweet(ctx, 3, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("expected repeat retweet to return %d, got %d", first.ID, again.ID)
+	}
+
+	nested, err := srv.Retweet(ctx, 4, first.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *nested.OriginalID != 1 {
+		t.Errorf("expected retweet of a retweet to point at tweet 1, got %d", *nested.OriginalID)
+	}
+
+	if _, err := srv.Retweet(ctx, 3, 42); !errors.Is(err, ErrTweetNotFound) {
+		t.Errorf("expected %v got %v", ErrTweetNotFound, err)
+	}
+
+	if err := srv.Unretweet(ctx, 3, 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := srv.Unretweet(ctx, 3, 1); !errors.Is(err, ErrNotRetweeted) {
+		t.Errorf("expected %v got %v", ErrNotRetweeted, err)
+	}
+}
+
+func TestServiceDelete(t *testing.T) {
+	reply := func(id int64) *int64 { return &id }
+
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, UserID: 2, Kind: KindTweet, Text: "root", ConversationID: 1},
+			2: {ID: 2, UserID: 3, Kind: KindTweet, Text: "reply", InReplyToID: reply(1), ConversationID: 1},
+			3: {ID: 3, UserID: 3, Kind: KindRetweet, OriginalID: reply(1)},
+		},
+	}
+	srv := NewService(context.Background(), repo)
+	ctx := context.Background()
+
+	tests := []struct{
+		name string
+		userId int64
+		tweetID int64
+		expectedError error
+	}{
+		{"someone else's tweet", 3, 1, ErrNotAuthor},
+		{"non-existing tweet", 2, 42, ErrTweetNotFound},
+		{"own tweet", 2, 1, nil},
+		{"already deleted tweet", 2, 1, ErrTweetDeleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := srv.Delete(ctx, tt.userId, tt.tweetID)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("expected error %v got %v", tt.expectedError, err)
+			}
+		})
+	}
+
+	if _, err := srv.Get(ctx, 1); !errors.Is(err, ErrTweetDeleted) {
+		t.Errorf("expected %v got %v", ErrTweetDeleted, err)
+	}
+
+	retweet, err := srv.Get(ctx, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !retweet.Original.Deleted || retweet.Original.Text != DeletedText {
+		t.Errorf("expected the retweeted original to be a tombstone, got %+v", retweet.Original)
+	}
+
+	thread, err := srv.GetThread(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(thread.Ancestors) != 1 || !thread.Ancestors[0].Deleted || thread.Ancestors[0].UserID != 0 {
+		t.Errorf("expected the deleted root as a tombstone ancestor, got %+v", thread.Ancestors)
+	}
+}
+
+func TestServiceEdit(t *testing.T) {
+	posted := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := &mockRepo{
+		tweets: map[int64]Tweet{
+			1: {ID: 1, UserID: 2, Kind: KindTweet, Text: "helo", CreatedAt: posted},
+		},
+	}
+	srv := NewService(context.Background(), repo, WithEditWindow(30 * time.Minute))
+	ctx := context.Background()
+
+	tests := []struct{
+		name string
+		now time.Time
+		userId int64
+		text string
+		expectedError error
+	}{
+		{"someone else's tweet", posted.Add(time.Minute), 3, "hello", ErrNotAuthor},
+		{"empty text", posted.Add(time.Minute), 2, "", ErrEmptyText},
+		{"text too long", posted.Add(time.Minute), 2, strings.Repeat("a", MaxTweetLength + 1), ErrTextTooLong},
+		{"within the edit window", posted.Add(time.Minute), 2, "hello", nil},
+		{"again within the edit window", posted.Add(2 * time.Minute), 2, "hello!", nil},
+		{"after the edit window", posted.Add(31 * time.Minute), 2, "hello?", ErrEditWindowClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.now = func() time.Time { return tt.now }
+			_, err := srv.Edit(ctx, tt.userId, 1, tt.text)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("expected error %v got %v", tt.expectedError, err)
+			}
+		})
+	}
+
+	tweet, err := srv.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tweet.Text != "hello!" || tweet.RevisionCount != 2 || tweet.EditedAt == nil {
+		t.Errorf("expected the second edit to be live with 2 revisions, got %+v", tweet)
+	}
+
+	history, err := srv.History(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 2 || history[0].Text != "helo" || history[1].Text != "hello" {
+		t.Errorf("expected revisions [helo hello], got %+v", history)
+	}
 }
\ No newline at end of file
@@ -9,7 +9,7 @@ type User struct {
 type Follow struct {
 	ID int64 `gorm:"primaryKey"`
 	FollowerID int64 `json:"followerId" gorm:"primaryKey"`
-	FollowedID int64 `json:"followedId" gorm:"primaryKey"`
+	FollowedID int64 `json:"followedId" gorm:"primaryKey;index"`
 	Follower User `gorm:"foreignKey:FollowerID"`
 	Followed User `gorm:"foreignKey:FollowedID"`
 }
\ No newline at end of file
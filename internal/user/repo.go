@@ -11,10 +11,19 @@ import (
 type UserRepo interface {
 	InsertUser(ctx context.Context, user *User) error
 	GetUserByUsername(ctx context.Context, username string) (User, error)
+	GetUsersByUsernames(ctx context.Context, usernames []string) ([]User, error)
+	GetUsersByIDs(ctx context.Context, userIds []int64) ([]User, error)
+	// GetUserIDs returns the IDs of up to count users after afterID, in
+	// order.
+	GetUserIDs(ctx context.Context, afterID int64, count int) ([]int64, error)
 
 	InsertFollow(ctx context.Context, followerId, followedId int64) error
 	DeleteFollow(ctx context.Context, followerId, followedId int64) error
 	GetFollows(ctx context.Context, userId int64) ([]Follow, error)
+	GetFollowers(ctx context.Context, userId int64) ([]Follow, error)
+	// CountFollowers returns the number of followers of each of userIds,
+	// users without followers are left out.
+	CountFollowers(ctx context.Context, userIds []int64) (map[int64]int64, error)
 }
 
 type userRepo struct {
@@ -37,6 +46,38 @@ func (r *userRepo) GetUserByUsername(ctx context.Context, username string) (User
 	return gorm.G[User](r.db).Where("username = ?", username).First(ctx)
 }
 
+func (r *userRepo) GetUsersByUsernames(ctx context.Context, usernames []string) ([]User, error) {
+	users, err := gorm.G[User](r.db).Where("username IN ?", usernames).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch users %v: %v", usernames, err)
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *userRepo) GetUsersByIDs(ctx context.Context, userIds []int64) ([]User, error) {
+	users, err := gorm.G[User](r.db).Where("id IN ?", userIds).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch users %v: %v", userIds, err)
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *userRepo) GetUserIDs(ctx context.Context, afterID int64, count int) ([]int64, error) {
+	users, err := gorm.G[User](r.db).Select("id").Where("id > ?", afterID).Order("id ASC").Limit(count).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch users after %d: %v", afterID, err)
+		return nil, err
+	}
+
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids, nil
+}
+
 func (r *userRepo) InsertFollow(ctx context.Context, followerId, followedId int64) error {
 	follow := Follow{
 		FollowerID: followerId,
@@ -71,4 +112,35 @@ func (r *userRepo) GetFollows(ctx context.Context, userId int64) ([]Follow, erro
 		return nil, err
 	}
 	return follows, nil
-}
\ No newline at end of file
+}
+
+func (r *userRepo) GetFollowers(ctx context.Context, userId int64) ([]Follow, error) {
+	followers, err := gorm.G[Follow](r.db).Where("followed_id = ?", userId).Find(ctx)
+	if err != nil {
+		log.Printf("could not fetch followers for userId=%d: %v", userId, err)
+		return nil, err
+	}
+	return followers, nil
+}
+
+func (r *userRepo) CountFollowers(ctx context.Context, userIds []int64) (map[int64]int64, error) {
+	var rows []struct {
+		FollowedID int64
+		Count int64
+	}
+	err := r.db.WithContext(ctx).Model(&Follow{}).
+		Select("followed_id, COUNT(*) AS count").
+		Where("followed_id IN ?", userIds).
+		Group("followed_id").
+		Scan(&rows).Error
+	if err != nil {
+		log.Printf("could not count followers of %v: %v", userIds, err)
+		return nil, err
+	}
+
+	counts := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		counts[row.FollowedID] = row.Count
+	}
+	return counts, nil
+}
@@ -5,11 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"log"
+
+	"gorm.io/gorm"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type UserService interface {
 	CreateUser(ctx context.Context, user *User) (*User, error)
 	GetByUsername(ctx context.Context, username string) (*User, error)
+	// GetByUsernames looks up many users in one go, unknown usernames are
+	// left out.
+	GetByUsernames(ctx context.Context, usernames []string) ([]User, error)
+	GetByIDs(ctx context.Context, userIds []int64) ([]User, error)
 
 	Follow(ctx context.Context, followerId, followedId int64) error
 	Unfollow(ctx context.Context, followerId, followedId int64) error
@@ -17,12 +25,31 @@ type UserService interface {
 	GetFollows(ctx context.Context, userId int64) ([]Follow, error)
 }
 
+// FollowListener is told about follows and unfollows once they are stored.
+type FollowListener interface {
+	Followed(ctx context.Context, followerId, followedId int64)
+	Unfollowed(ctx context.Context, followerId, followedId int64)
+}
+
+type Option func(*userService)
+
+func WithFollowListener(l FollowListener) Option {
+	return func(s *userService) {
+		s.listeners = append(s.listeners, l)
+	}
+}
+
 type userService struct {
 	repo UserRepo
+	listeners []FollowListener
 }
 
-func NewService(repo UserRepo) *userService {
-	return &userService{repo: repo}
+func NewService(repo UserRepo, opts ...Option) *userService {
+	s := &userService{repo: repo}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *userService) CreateUser(ctx context.Context, user *User) (*User, error) {
@@ -36,14 +63,50 @@ func (s *userService) CreateUser(ctx context.Context, user *User) (*User, error)
 
 func (s *userService) GetByUsername(ctx context.Context, username string) (*User, error) {
 	user, err := s.repo.GetUserByUsername(ctx, username)
-	if err != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		log.Printf("user not found: %s", username)
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		log.Printf("could not fetch user %s: %v", username, err)
+		return nil, err
 	}
 
 	return &user, nil
 }
 
+func (s *userService) GetByUsernames(ctx context.Context, usernames []string) ([]User, error) {
+	if len(usernames) == 0 {
+		return []User{}, nil
+	}
+	return s.repo.GetUsersByUsernames(ctx, usernames)
+}
+
+// GetByIDs returns the users in the order of userIds, skipping unknown IDs.
+func (s *userService) GetByIDs(ctx context.Context, userIds []int64) ([]User, error) {
+	if len(userIds) == 0 {
+		return []User{}, nil
+	}
+
+	users, err := s.repo.GetUsersByIDs(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+
+	ordered := make([]User, 0, len(users))
+	for _, id := range userIds {
+		if u, ok := byID[id]; ok {
+			ordered = append(ordered, u)
+		}
+	}
+	return ordered, nil
+}
+
 func (s *userService) Follow(ctx context.Context, followerId, followedId int64) error {
 	if followerId == followedId {
 		return errors.New("userId cannot be the same as targetUserId")
@@ -53,6 +116,10 @@ func (s *userService) Follow(ctx context.Context, followerId, followedId int64)
 		return errors.New("could not follow user")
 	}
 
+	for _, l := range s.listeners {
+		l.Followed(ctx, followerId, followedId)
+	}
+
 	return nil
 }
 
@@ -61,7 +128,14 @@ func (s *userService) Unfollow(ctx context.Context, followerId, followedId int64
 		return errors.New("userId cannot be the same as targetUserId")
 	}
 
-	return s.repo.DeleteFollow(ctx, followerId, followedId)
+	if err := s.repo.DeleteFollow(ctx, followerId, followedId); err != nil {
+		return err
+	}
+
+	for _, l := range s.listeners {
+		l.Unfollowed(ctx, followerId, followedId)
+	}
+	return nil
 }
 
 func (s *userService) GetFollows(ctx context.Context, userId int64) ([]Follow, error) {
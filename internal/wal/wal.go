@@ -0,0 +1,298 @@
+// Package wal is an append-only write-ahead log for work that has been
+// acknowledged to clients but not yet applied. Entries are fsynced before
+// Append returns and stay pending until they are acked, so whatever is
+// still pending after a crash can be replayed on startup.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	recordEntry byte = 1
+	recordAck byte = 2
+
+	// header is the payload length, its checksum, the record type and the
+	// sequence number
+	headerSize = 4 + 4 + 1 + 8
+
+	// defaultCompactSize is how large the log may grow before it is
+	// truncated, which only happens once nothing is pending anymore
+	defaultCompactSize = 64 << 20
+)
+
+var ErrClosed = errors.New("wal is closed")
+
+type Entry struct {
+	Seq uint64
+	Payload []byte
+}
+
+type Log struct {
+	mu sync.Mutex
+	f *os.File
+	nextSeq uint64
+	pending map[uint64][]byte
+	size int64
+	compactSize int64
+	closed bool
+	// failed is set once a failed write could not be cut off again, after
+	// which the log takes no more writes
+	failed error
+
+	// synced is how much of the log is known to be on disk, syncMu
+	// serializes fsyncs so that concurrent appends share one
+	synced int64
+	syncMu sync.Mutex
+}
+
+// Open opens or creates the log at path and loads the entries that were
+// never acked. A torn record at the end, left by a crash mid-write, is cut off.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening wal: %v", err)
+	}
+
+	l := &Log{
+		f: f,
+		nextSeq: 1,
+		pending: map[uint64][]byte{},
+		compactSize: defaultCompactSize,
+	}
+	if err := l.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) load() error {
+	r := bufio.NewReader(l.f)
+	var valid int64
+
+	for {
+		typ, seq, payload, n, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		valid += n
+
+		switch typ {
+		case recordEntry:
+			l.pending[seq] = payload
+		case recordAck:
+			for i := 0; i+8 <= len(payload); i += 8 {
+				delete(l.pending, binary.BigEndian.Uint64(payload[i:]))
+			}
+		}
+		if seq >= l.nextSeq {
+			l.nextSeq = seq + 1
+		}
+	}
+
+	if err := l.f.Truncate(valid); err != nil {
+		return fmt.Errorf("error truncating wal: %v", err)
+	}
+	if _, err := l.f.Seek(valid, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = valid
+	l.synced = valid
+	return nil
+}
+
+func readRecord(r io.Reader) (typ byte, seq uint64, payload []byte, n int64, err error) {
+	var header [headerSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(header[0:])
+	checksum := binary.BigEndian.Uint32(header[4:])
+	typ = header[8]
+	seq = binary.BigEndian.Uint64(header[9:])
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(payload)
+	if crc.Sum32() != checksum {
+		err = errors.New("checksum mismatch")
+		return
+	}
+
+	return typ, seq, payload, int64(headerSize + len(payload)), nil
+}
+
+func encodeRecord(typ byte, seq uint64, payload []byte) []byte {
+	buf := make([]byte, headerSize + len(payload))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(payload)))
+	buf[8] = typ
+	binary.BigEndian.PutUint64(buf[9:], seq)
+	copy(buf[headerSize:], payload)
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(buf[8:]))
+	return buf
+}
+
+// Append durably writes payload and returns its sequence number.
+func (l *Log) Append(payload []byte) (uint64, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	seq := l.nextSeq
+	if err := l.write(encodeRecord(recordEntry, seq, payload)); err != nil {
+		l.mu.Unlock()
+		return 0, err
+	}
+	l.nextSeq++
+	l.pending[seq] = payload
+	end := l.size
+	l.mu.Unlock()
+
+	if err := l.sync(end); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// sync makes sure everything up to offset end is on disk. Appends that
+// wait on an fsync in progress get covered by the next single one.
+func (l *Log) sync(end int64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	l.mu.Lock()
+	if l.synced >= end {
+		l.mu.Unlock()
+		return nil
+	}
+	size := l.size
+	l.mu.Unlock()
+
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.synced = size
+	l.mu.Unlock()
+	return nil
+}
+
+// Ack marks entries as applied so they are not replayed. Acks are not
+// fsynced, losing one only means its entries get replayed once more.
+func (l *Log) Ack(seqs ...uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	payload := make([]byte, 0, 8*len(seqs))
+	for _, seq := range seqs {
+		payload = binary.BigEndian.AppendUint64(payload, seq)
+	}
+
+	// entries of a failed ack stay pending so they are acked again later
+	if err := l.write(encodeRecord(recordAck, 0, payload)); err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		delete(l.pending, seq)
+	}
+
+	if len(l.pending) == 0 && l.size > l.compactSize {
+		return l.truncate()
+	}
+	return nil
+}
+
+// write appends a record, the caller holds mu. A write that fails part way
+// is cut off again, otherwise loading the log would stop at the torn record
+// and lose every record after it. If that fails as well, the log refuses
+// any further writes.
+func (l *Log) write(record []byte) error {
+	if l.failed != nil {
+		return l.failed
+	}
+
+	if _, err := l.f.Write(record); err != nil {
+		if terr := l.rewind(); terr != nil {
+			l.failed = fmt.Errorf("wal is unusable after a failed write: %v", err)
+		}
+		return err
+	}
+	l.size += int64(len(record))
+	return nil
+}
+
+// rewind cuts the log off after the last record written in full.
+func (l *Log) rewind() error {
+	if err := l.f.Truncate(l.size); err != nil {
+		return err
+	}
+	_, err := l.f.Seek(l.size, io.SeekStart)
+	return err
+}
+
+// truncate empties the log, the caller holds mu and nothing is pending,
+// so no append can be waiting on an fsync.
+func (l *Log) truncate() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = 0
+	l.synced = 0
+	return l.f.Sync()
+}
+
+// Pending returns the entries that were appended but never acked, oldest first.
+func (l *Log) Pending() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0, len(l.pending))
+	for seq, payload := range l.pending {
+		entries = append(entries, Entry{Seq: seq, Payload: payload})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,187 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for _, payload := range []string{"a", "b", "c"} {
+		seq, err := l.Append([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if err := l.Ack(seqs[0], seqs[2]); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	pending := l.Pending()
+	if len(pending) != 1 || pending[0].Seq != seqs[1] || string(pending[0].Payload) != "b" {
+		t.Fatalf("expected only b to be pending, got %+v", pending)
+	}
+
+	seq, err := l.Append([]byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq <= seqs[2] {
+		t.Errorf("expected sequence numbers to continue after reopening, got %d", seq)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append([]byte("complete")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append([]byte("torn")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// cut the last record short, as a crash in the middle of a write would
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := l.Pending()
+	if len(pending) != 1 || string(pending[0].Payload) != "complete" {
+		t.Fatalf("expected only the complete record, got %+v", pending)
+	}
+
+	// the torn tail is dropped so new records are readable after it
+	if _, err := l.Append([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if pending := l.Pending(); len(pending) != 2 || string(pending[1].Payload) != "after" {
+		t.Errorf("expected the record after the torn one to survive, got %+v", pending)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.compactSize = 100
+
+	first, err := l.Append(make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Append(make([]byte, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// over the threshold but second is still pending
+	if err := l.Ack(first); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() == 0 {
+		t.Fatalf("expected the log to be kept while entries are pending")
+	}
+
+	if err := l.Ack(second); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("expected the log to be truncated, size is %d", info.Size())
+	}
+
+	// the log is usable after truncating
+	if _, err := l.Append([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if pending := l.Pending(); len(pending) != 1 || string(pending[0].Payload) != "next" {
+		t.Errorf("expected only the entry appended after truncating, got %+v", pending)
+	}
+}
+
+func TestFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := l.Append([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither writes nor truncating go through on a read-only file
+	f := l.f
+	if l.f, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Ack(seq); err == nil {
+		t.Fatal("expected the ack to fail")
+	}
+	if pending := l.Pending(); len(pending) != 1 {
+		t.Errorf("expected a failed ack to leave the entry pending, got %+v", pending)
+	}
+
+	l.f.Close()
+	l.f = f
+	if _, err := l.Append([]byte("b")); err == nil {
+		t.Error("expected the log to refuse appends after a write it could not cut off")
+	}
+	l.Close()
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if pending := l.Pending(); len(pending) != 1 || string(pending[0].Payload) != "a" {
+		t.Errorf("expected only a to be pending, got %+v", pending)
+	}
+}
@@ -2,15 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/daniiltsioma/twitter/internal/auth"
+	"github.com/daniiltsioma/twitter/internal/idempotency"
+	"github.com/daniiltsioma/twitter/internal/like"
+	"github.com/daniiltsioma/twitter/internal/media"
+	"github.com/daniiltsioma/twitter/internal/snowflake"
 	"github.com/daniiltsioma/twitter/internal/timeline"
 	"github.com/daniiltsioma/twitter/internal/tweet"
 	"github.com/daniiltsioma/twitter/internal/user"
+	"github.com/daniiltsioma/twitter/internal/wal"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/jwtauth"
 	"gorm.io/driver/postgres"
@@ -20,6 +31,10 @@ import (
 var db *gorm.DB
 var tokenAuth *jwtauth.JWTAuth
 
+// shutdownTimeout bounds how long in-flight requests and the drain of the
+// batching queues may take once a shutdown signal arrives.
+const shutdownTimeout = 30 * time.Second
+
 func init() {
 	tokenAuth = jwtauth.New("HS256", []byte("secret"), nil)
 }
@@ -31,30 +46,194 @@ func main() {
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbName := os.Getenv("DB_NAME")
 
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
+	// every instance needs its own worker ID for tweet IDs to be unique
+	var workerID int64
+	if v := os.Getenv("WORKER_ID"); v != "" {
+		if workerID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			log.Fatalf("invalid WORKER_ID: %v", err)
+		}
+	}
+	tweetIDs, err := snowflake.New(workerID)
+	if err != nil {
+		log.Fatalf("invalid WORKER_ID: %v", err)
+	}
+
+	idempotencyTTL := idempotency.DefaultTTL
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if idempotencyTTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid IDEMPOTENCY_TTL: %v", err)
+		}
+	}
+
+	batching, err := batchConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid tweet batching config: %v", err)
+	}
+
+	spoolPath := os.Getenv("TWEET_SPOOL")
+	if spoolPath == "" {
+		spoolPath = "tweets.wal"
+	}
+
+	editWindow := tweet.DefaultEditWindow
+	if v := os.Getenv("TWEET_EDIT_WINDOW"); v != "" {
+		if editWindow, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid TWEET_EDIT_WINDOW: %v", err)
+		}
+	}
+
+	timelineStore := os.Getenv("TIMELINE_STORE")
+	switch timelineStore {
+	case "", "memory", "postgres":
+	default:
+		log.Fatalf("invalid TIMELINE_STORE %q, expected memory or postgres", timelineStore)
+	}
+
+	// authors with more followers than this are pulled into timelines when
+	// they are read instead of being fanned out, for good once they cross
+	// it, 0 fans out everyone
+	var pullThreshold int64
+	if v := os.Getenv("TIMELINE_PULL_THRESHOLD"); v != "" {
+		if pullThreshold, err = strconv.ParseInt(v, 10, 64); err != nil || pullThreshold < 0 {
+			log.Fatalf("invalid TIMELINE_PULL_THRESHOLD: %q", v)
+		}
+	}
+
 	dsn := fmt.Sprintf("host=postgres port=5432 user=%s password=%s dbname=%s sslmode=disable", dbUser, dbPassword, dbName)
 	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
 
-	db.AutoMigrate(&tweet.Tweet{}, &user.User{}, &user.Follow{}, &auth.Credentials{})
+	// likes used to be counted on read, the existing ones are counted once
+	// when the table for the counts is created
+	countLikes := !db.Migrator().HasTable(&like.LikeCount{})
+
+	db.AutoMigrate(&tweet.Tweet{}, &user.User{}, &user.Follow{}, &auth.Credentials{}, &like.Like{}, &like.LikeCount{}, &tweet.Revision{}, &tweet.TweetHashtag{}, &tweet.Mention{}, &tweet.TweetMedia{}, &media.Media{}, &tweet.DeadLetter{}, &tweet.OutboxEntry{}, &idempotency.Record{}, &timeline.TimelineEntry{}, &timeline.PulledAuthor{})
+
+	// idx_user_tweets on (user_id, id) replaced idx_user_created on
+	// (user_id, created_at) once IDs became time-ordered, AutoMigrate only
+	// adds indexes and leaves the old one behind
+	if db.Migrator().HasIndex(&tweet.Tweet{}, "idx_user_created") {
+		if err := db.Migrator().DropIndex(&tweet.Tweet{}, "idx_user_created"); err != nil {
+			log.Printf("could not drop index idx_user_created: %v", err)
+		}
+	}
 
-	// app context
-	ctx := context.Background()
+	// app context, it outlives the server so the workers can drain the
+	// queues after the last request has been answered
+	ctx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
 
 	authRepo := auth.NewRepo(db)
 	userRepo := user.NewRepo(db)
 	tweetRepo := tweet.NewRepo(db)
+	deadLetterRepo := tweet.NewDeadLetterRepo(db)
+	likeRepo := like.NewRepo(db)
+	if countLikes {
+		if err := likeRepo.Recount(ctx); err != nil {
+			log.Fatalf("failed to count existing likes: %v", err)
+		}
+	}
+	mediaRepo := media.NewRepo(db)
+	idempotencyRepo := idempotency.NewRepo(db)
+
+	blobStore, err := media.NewFSStore(mediaDir)
+	if err != nil {
+		log.Fatalf("failed to open media store: %v", err)
+	}
+
+	// without a store timelines are put together when they are read,
+	// with one they are written ahead by fanning out every tweet
+	var timelines timeline.TimelineStore
+	switch timelineStore {
+	case "memory":
+		timelines = timeline.NewMemoryStore()
+	case "postgres":
+		timelines = timeline.NewPostgresStore(db)
+	}
+
+	var userOpts []user.Option
+	var tweetOpts []tweet.Option
+	var timelineOpts []timeline.Option
+	var fanout *timeline.Fanout
+	if timelines != nil {
+		var pull *timeline.PullPolicy
+		if pullThreshold > 0 {
+			pull = timeline.NewPullPolicy(pullThreshold, userRepo, timelines)
+			timelineOpts = append(timelineOpts, timeline.WithPull(pull))
+		}
+		fanout = timeline.NewFanout(timelines, userRepo, tweetRepo, pull)
+		userOpts = append(userOpts, user.WithFollowListener(fanout))
+		tweetOpts = append(tweetOpts, tweet.WithListener(fanout))
+		timelineOpts = append(timelineOpts, timeline.WithStore(timelines))
+	}
 
-	tweetService := tweet.NewService(ctx, tweetRepo)
-	userService := user.NewService(userRepo)
+	userService := user.NewService(userRepo, userOpts...)
+	likeService := like.NewService(likeRepo, userService)
+	mediaService := media.NewService(mediaRepo, blobStore)
+	tweetOpts = append(tweetOpts,
+		tweet.WithDecorator(likeService),
+		tweet.WithUserLookup(userService),
+		tweet.WithProfileLookup(userService),
+		tweet.WithMedia(mediaService),
+		tweet.WithEditWindow(editWindow),
+		tweet.WithIDs(tweetIDs),
+	)
+	tweetService := tweet.NewService(ctx, tweetRepo, tweetOpts...)
+	deadLetterService := tweet.NewDeadLetterService(deadLetterRepo, tweetService)
 	authService := auth.NewService(authRepo, userService, tokenAuth)
-	timelineService := timeline.NewService(tweetService, userService)
+	timelineService := timeline.NewService(tweetService, userService, timelineOpts...)
+	idempotencyService := idempotency.NewService(ctx, idempotencyRepo, idempotencyTTL)
 
-	tweetHandler := tweet.NewHandler(ctx, tweetService)
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:], deadLetterService, fanout, userRepo); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// the memory store is empty on every start
+	if timelineStore == "memory" {
+		filled, err := fanout.BackfillAll(ctx, userRepo)
+		if err != nil {
+			log.Fatalf("failed to backfill timelines: %v", err)
+		}
+		log.Printf("backfilled %d timelines", filled)
+	}
+
+	spool, err := wal.Open(spoolPath)
+	if err != nil {
+		log.Fatalf("failed to open tweet spool: %v", err)
+	}
+
+	tweetHandler := tweet.NewHandler(ctx, tweetService,
+		tweet.WithSpool(spool),
+		tweet.WithDeadLetters(deadLetterService),
+		tweet.WithBatching(batching),
+	)
+	replayed, err := tweetHandler.Replay(ctx)
+	if err != nil {
+		log.Fatalf("failed to replay tweet spool: %v", err)
+	}
+	if replayed > 0 {
+		log.Printf("replayed %d spooled tweets", replayed)
+	}
 	userHandler := user.NewHandler(userService)
 	authHandler := auth.NewHandler(authService)
 	timelineHandler := timeline.NewHandler(timelineService)
+	likeHandler := like.NewHandler(ctx, likeService, tweetService)
+	mediaHandler := media.NewHandler(mediaService)
+	deadLetterHandler := tweet.NewDeadLetterHandler(deadLetterService)
+
+	idempotent := idempotency.Middleware(idempotencyService)
 
 	r := chi.NewRouter()
 
@@ -63,24 +242,220 @@ func main() {
 			r.Use(jwtauth.Verifier(tokenAuth))
 			r.Use(auth.Authenticator)
 		
-			r.Post("/tweet", tweetHandler.PostTweet)
+			r.With(idempotent).Post("/tweet", tweetHandler.PostTweet)
+			r.With(idempotent).Post("/thread", tweetHandler.PostThread)
+			r.Patch("/tweet/{tweetID}", tweetHandler.EditTweet)
+			r.Delete("/tweet/{tweetID}", tweetHandler.DeleteTweet)
+			r.Post("/tweet/{tweetID}/retweet", tweetHandler.Retweet)
+			r.Delete("/tweet/{tweetID}/retweet", tweetHandler.Unretweet)
+			r.Post("/tweet/{tweetID}/like", likeHandler.Like)
+			r.Delete("/tweet/{tweetID}/like", likeHandler.Unlike)
 			
-			r.Post("/follow/{targetUserId}", userHandler.FollowUser)
+			r.With(idempotent).Post("/follow/{targetUserId}", userHandler.FollowUser)
 			r.Delete("/follow/{targetUserId}", userHandler.UnfollowUser)
 			
 			r.Get("/timeline", timelineHandler.GetTweets)
+			r.Get("/mentions", tweetHandler.GetMentions)
+
+			r.Post("/media", mediaHandler.Upload)
 		})
 		
 		r.Group(func(r chi.Router) {
-			r.Post("/register", authHandler.Register)
+			r.Use(jwtauth.Verifier(tokenAuth))
+			r.Use(auth.OptionalAuthenticator)
+
+			r.With(idempotent).Post("/register", authHandler.Register)
 			r.Post("/login", authHandler.Login)
 
 			r.Get("/tweet/{tweetID}", tweetHandler.GetTweet)
+			r.Get("/tweet/{tweetID}/thread", tweetHandler.GetThread)
+			r.Get("/tweet/{tweetID}/history", tweetHandler.GetHistory)
+			r.Get("/tweet/{tweetID}/likes", likeHandler.GetLikes)
+
+			r.Get("/hashtag/{tag}", tweetHandler.GetHashtag)
+
+			r.Get("/users/{userId}/tweets", tweetHandler.GetUserTweets)
+			r.Get("/users/by-username/{username}/tweets", tweetHandler.GetUserTweets)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(auth.AdminToken(adminToken))
+
+			r.Get("/deadletters", deadLetterHandler.List)
+			r.Post("/deadletters/{deadLetterID}/replay", deadLetterHandler.Replay)
+			r.Delete("/deadletters/{deadLetterID}", deadLetterHandler.Discard)
 		})
 	})
 
-	fmt.Printf("server listening on port 8080\n")
-	if err = http.ListenAndServe(":8080", r); err != nil {
-		fmt.Printf("%v\n", err)
+	r.Get("/media/{mediaID}", mediaHandler.Serve)
+
+	srv := &http.Server{Addr: ":8080", Handler: r}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		fmt.Printf("server listening on port 8080\n")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server failed: %v", err)
+		}
+	}()
+
+	<-sigCtx.Done()
+	stop()
+	log.Printf("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("could not shut down server: %v", err)
+	}
+
+	drained, err := tweetHandler.Close(shutdownCtx)
+	if err != nil {
+		log.Printf("could not drain tweet queue, the rest stays spooled: %v", err)
+	}
+	log.Printf("drained %d queued tweets", drained)
+
+	drained, err = likeHandler.Close(shutdownCtx)
+	if err != nil {
+		log.Printf("could not drain like queue: %v", err)
+	}
+	log.Printf("drained %d queued like operations", drained)
+
+	// a Close that timed out leaves its workers flushing, they have to stop
+	// before the spool and the database go away under them
+	cancelApp()
+	tweetHandler.Wait()
+	likeHandler.Wait()
+
+	if err := spool.Close(); err != nil {
+		log.Printf("could not close tweet spool: %v", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// batchConfigFromEnv reads the TWEET_BATCH_*, TWEET_QUEUE_SIZE and
+// TWEET_WORKERS variables, anything unset keeps its default.
+func batchConfigFromEnv() (tweet.BatchConfig, error) {
+	cfg := tweet.DefaultBatchConfig()
+
+	ints := map[string]*int{
+		"TWEET_BATCH_SIZE": &cfg.MaxBatchSize,
+		"TWEET_BATCH_MIN_SIZE": &cfg.MinBatchSize,
+		"TWEET_QUEUE_SIZE": &cfg.QueueSize,
+		"TWEET_WORKERS": &cfg.Workers,
+	}
+	for name, dst := range ints {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+			*dst = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"TWEET_BATCH_WAIT": &cfg.MaxWait,
+		"TWEET_BATCH_MIN_WAIT": &cfg.MinWait,
+		"TWEET_BATCH_SYNC_WAIT": &cfg.SyncWait,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+			*dst = d
+		}
+	}
+
+	if v := os.Getenv("TWEET_BATCH_ADAPTIVE"); v != "" {
+		adaptive, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("TWEET_BATCH_ADAPTIVE: %v", err)
+		}
+		cfg.Adaptive = adaptive
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// runCommand runs an admin command instead of the server:
+//
+//	api deadletters list
+//	api deadletters replay <id>
+//	api deadletters discard <id>
+//	api timelines backfill
+//
+// timelines backfill fills the timeline store from the tweets and follows
+// already stored. Run it with TIMELINE_STORE set before serving from a new
+// store, or timelines come up empty.
+func runCommand(ctx context.Context, args []string, deadLetters tweet.DeadLetterService, fanout *timeline.Fanout, users timeline.UserLister) error {
+	if len(args) == 2 && args[0] == "timelines" && args[1] == "backfill" {
+		if fanout == nil {
+			return errors.New("TIMELINE_STORE is not set, there is no store to backfill")
+		}
+		filled, err := fanout.BackfillAll(ctx, users)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("backfilled %d timelines\n", filled)
+		return nil
+	}
+
+	usage := errors.New("usage: api deadletters list | replay <id> | discard <id>, api timelines backfill")
+	if args[0] != "deadletters" || len(args) < 2 {
+		return usage
+	}
+
+	if args[1] == "list" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tCREATED\tERROR\tTEXT")
+
+		var afterID int64
+		for {
+			dls, err := deadLetters.List(ctx, afterID, 100)
+			if err != nil {
+				return err
+			}
+			if len(dls) == 0 {
+				break
+			}
+			for _, dl := range dls {
+				fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%q\n", dl.ID, dl.Tweet.UserID, dl.CreatedAt.Format(time.RFC3339), dl.Error, dl.Tweet.Text)
+			}
+			afterID = dls[len(dls)-1].ID
+		}
+		return w.Flush()
+	}
+
+	if len(args) != 3 {
+		return usage
+	}
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid dead letter id %q", args[2])
+	}
+
+	switch args[1] {
+	case "replay":
+		t, err := deadLetters.Replay(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("stored as tweet %d\n", t.ID)
+	case "discard":
+		if err := deadLetters.Discard(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("discarded dead letter %d\n", id)
+	default:
+		return usage
 	}
+	return nil
 }